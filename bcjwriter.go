@@ -0,0 +1,101 @@
+/*
+ * Package xz Go BCJ filter Writer API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import "io"
+
+// bcjChunkSize is the largest amount of input converted at once. This
+// bounds the size of a BCJWriter's buffer.
+const bcjChunkSize = 1 << 16 // 64 KiB
+
+// A BCJWriter is an io.WriteCloser which applies the encoding
+// direction of a branch/call/jump (BCJ) filter to the data written to
+// it, converting the relative addresses of branch instructions in
+// executable code to absolute ones, which usually makes the code
+// compress better. The converted data is written to the underlying
+// io.Writer.
+//
+// Data converted by a BCJWriter and then compressed is restored by
+// the decoder when the filter, with the same start offset, precedes
+// LZMA2 in the filter chain of the block, as done by the XZ Utils
+// options --x86, --powerpc, --ia64, --arm, --armthumb, --sparc and
+// --arm64.
+type BCJWriter struct {
+	w      io.Writer // the wrapped io.Writer
+	enc    xzEncBCJ  // encoder state
+	buf    []byte    // data not yet converted or written
+	closed bool      // true after Close has been called
+	err    error     // sticky error
+}
+
+// NewBCJWriter returns a new BCJWriter using filter f, whose ID must
+// be one of FilterX86, FilterPowerPC, FilterIA64, FilterARM,
+// FilterARMThumb, FilterSPARC and FilterARM64. f.Props is the start
+// offset, the address at which the data is assumed to begin, which is
+// usually zero. It must be a multiple of the instruction alignment of
+// the filter, which is 4 for PowerPC, ARM, SPARC and ARM64, 16 for
+// IA-64 and 2 for ARM-Thumb.
+//
+// ErrOptions is returned if f is not a supported BCJ filter or the
+// start offset is not aligned.
+func NewBCJWriter(w io.Writer, f Filter) (*BCJWriter, error) {
+	z := &BCJWriter{w: w}
+	if xzEncBCJReset(&z.enc, xzFilterID(f.ID), int(f.Props)) != xzOK {
+		return nil, ErrOptions
+	}
+	return z, nil
+}
+
+// Write converts p and writes it to the underlying io.Writer. The
+// last few bytes written may be held back until more data is written
+// or the BCJWriter is closed, as an instruction may continue in them.
+func (z *BCJWriter) Write(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errWriterClosed
+	}
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > bcjChunkSize {
+			chunk = chunk[:bcjChunkSize]
+		}
+		z.buf = append(z.buf, chunk...)
+		filtered := xzEncBCJRun(&z.enc, z.buf)
+		if filtered > 0 {
+			if _, z.err = z.w.Write(z.buf[:filtered]); z.err != nil {
+				return n, z.err
+			}
+			z.buf = z.buf[:copy(z.buf, z.buf[filtered:])]
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close writes the bytes held back to the underlying io.Writer. As
+// for the decoder, the last bytes of the data are left unconverted.
+// Close does not close the underlying io.Writer.
+func (z *BCJWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if len(z.buf) > 0 {
+		_, z.err = z.w.Write(z.buf)
+		z.buf = z.buf[:0]
+	}
+	return z.err
+}
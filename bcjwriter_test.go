@@ -0,0 +1,225 @@
+/*
+ * Package xz BCJWriter tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+// bcjEncode converts data with a BCJWriter using filter f, writing it
+// in pieces of at most size bytes.
+func bcjEncode(t *testing.T, data []byte, f xz.Filter, size int) []byte {
+	c := new(bytes.Buffer)
+	w, err := xz.NewBCJWriter(c, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := data; len(p) > 0; {
+		n := size
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return c.Bytes()
+}
+
+// putVLI appends the variable-length integer x to b.
+func putVLI(b []byte, x uint64) []byte {
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
+
+// putCRC32 appends the CRC32 of b[start:] to b.
+func putCRC32(b []byte, start int) []byte {
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(b[start:]))
+	return append(b, crc[:]...)
+}
+
+// bcjFile returns an XZ file holding a single block whose filter chain
+// is f followed by LZMA2, where filtered is the data after conversion
+// by f. The LZMA2 data is stored in uncompressed chunks.
+func bcjFile(filtered []byte, f xz.Filter) []byte {
+	file := []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00, 0x00}
+	file = putCRC32(file, 6)
+	// Block Header
+	start := len(file)
+	file = append(file, 0x00, 0x01, byte(f.ID))
+	if f.Props == 0 {
+		file = append(file, 0x00)
+	} else {
+		file = append(file, 0x04, byte(f.Props), byte(f.Props>>8),
+			byte(f.Props>>16), byte(f.Props>>24))
+	}
+	file = append(file, 0x21, 0x01, 0x00)
+	for (len(file)-start)&3 != 0 {
+		file = append(file, 0x00)
+	}
+	file[start] = byte((len(file) - start) / 4)
+	file = putCRC32(file, start)
+	headerSize := len(file) - start
+	// Compressed Data
+	dataStart := len(file)
+	control := byte(0x01)
+	for p := filtered; len(p) > 0; {
+		n := len(p)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		file = append(file, control, byte((n-1)>>8), byte(n-1))
+		file = append(file, p[:n]...)
+		p = p[n:]
+		control = 0x02
+	}
+	file = append(file, 0x00)
+	unpadded := headerSize + len(file) - dataStart
+	for len(file)&3 != 0 {
+		file = append(file, 0x00)
+	}
+	// Index
+	start = len(file)
+	file = append(file, 0x00, 0x01)
+	file = putVLI(file, uint64(unpadded))
+	file = putVLI(file, uint64(len(filtered)))
+	for (len(file)-start)&3 != 0 {
+		file = append(file, 0x00)
+	}
+	file = putCRC32(file, start)
+	// Stream Footer
+	footer := make([]byte, 12)
+	binary.LittleEndian.PutUint32(footer[4:], uint32((len(file)-start)/4-1))
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(footer[4:10]))
+	copy(footer[10:], "YZ")
+	return append(file, footer...)
+}
+
+func TestBCJWriterRoundTrip(t *testing.T) {
+	// random data holds enough byte patterns that look like branch
+	// instructions to exercise every filter
+	random := make([]byte, 1<<17)
+	rand.New(rand.NewSource(1)).Read(random)
+	words := decodeTestFile(t, "words.xz")
+	for _, f := range []xz.Filter{
+		{ID: xz.FilterX86},
+		{ID: xz.FilterX86, Props: 2049},
+		{ID: xz.FilterPowerPC},
+		{ID: xz.FilterPowerPC, Props: 1 << 20},
+		{ID: xz.FilterIA64},
+		{ID: xz.FilterIA64, Props: 4096},
+		{ID: xz.FilterARM},
+		{ID: xz.FilterARM, Props: 8},
+		{ID: xz.FilterARMThumb},
+		{ID: xz.FilterARMThumb, Props: 2},
+		{ID: xz.FilterSPARC},
+		{ID: xz.FilterSPARC, Props: 4},
+		{ID: xz.FilterARM64},
+		{ID: xz.FilterARM64, Props: 1 << 12},
+	} {
+		for i, data := range [][]byte{random, words, random[:3]} {
+			filtered := bcjEncode(t, data, f, 1<<20)
+			if i == 0 && bytes.Equal(filtered, data) {
+				t.Fatalf("%v: random data was not changed", f)
+			}
+			for _, size := range []int{1, 1000} {
+				if got := bcjEncode(t, data, f, size); !bytes.Equal(got, filtered) {
+					t.Fatalf("%v: writes of %d bytes returned different data",
+						f, size)
+				}
+			}
+			r, err := xz.NewReader(bytes.NewReader(bcjFile(filtered, f)), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%v: %v", f, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%v: round trip returned different data", f)
+			}
+		}
+	}
+}
+
+func TestBCJWriterXZUtils(t *testing.T) {
+	// the filtered data held in files made by XZ Utils is recovered
+	// by decompressing their LZMA2 data alone
+	for _, file := range []string{
+		"good-1-x86-lzma2.xz",
+		"good-1-x86-lzma2-offset-2048.xz",
+		"good-1-sparc-lzma2.xz",
+		"good-1-arm64-lzma2.xz",
+		"good-1-arm64-lzma2-offset-2048.xz",
+	} {
+		data, err := readTestFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := xz.NewReader(bytes.NewReader(data), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := r.NextBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lr, err := xz.NewLZMA2Reader(
+			bytes.NewReader(data[h.Offset+int64(h.HeaderSize):]), h.DictSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ioutil.ReadAll(lr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bcjEncode(t, orig, h.Filters[0], 1<<20); !bytes.Equal(got, want) {
+			t.Fatalf("%s: BCJWriter returned different data", file)
+		}
+	}
+}
+
+func TestBCJWriterOptions(t *testing.T) {
+	for _, f := range []xz.Filter{
+		{ID: xz.FilterLZMA2},
+		{ID: xz.FilterDelta},
+		{ID: 0x0b},
+		{ID: xz.FilterPowerPC, Props: 2},
+		{ID: xz.FilterIA64, Props: 8},
+		{ID: xz.FilterARM, Props: 1},
+		{ID: xz.FilterARMThumb, Props: 1},
+		{ID: xz.FilterSPARC, Props: 6},
+		{ID: xz.FilterARM64, Props: 2},
+	} {
+		if _, err := xz.NewBCJWriter(ioutil.Discard, f); err != xz.ErrOptions {
+			t.Fatalf("%v: wanted error: %v, got: %v", f, xz.ErrOptions, err)
+		}
+	}
+}
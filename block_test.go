@@ -0,0 +1,151 @@
+/*
+ * Package xz block iteration tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+func TestNextBlock(t *testing.T) {
+	var data []byte
+	for _, file := range []string{"words-blocks.xz", "good-2-lzma2.xz"} {
+		b, err := readTestFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+	want, err := xz.Decode(nil, data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := xz.ReadIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []xz.BlockInfo
+	for _, st := range idx.Streams {
+		blocks = append(blocks, st.Blocks...)
+	}
+	for _, partial := range []bool{false, true} {
+		z, err := xz.NewReader(bytes.NewReader(data), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; ; i++ {
+			h, err := z.NextBlock()
+			if err == io.EOF && i == len(blocks) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("block %d: %v", i, err)
+			}
+			b := blocks[i]
+			if h.Offset != b.Offset ||
+				h.UncompressedOffset != b.UncompressedOffset ||
+				h.CompressedSize != -1 &&
+					h.CompressedSize+int64(h.HeaderSize) > b.UnpaddedSize ||
+				h.UncompressedSize != -1 &&
+					h.UncompressedSize != b.UncompressedSize ||
+				len(h.Filters) != 1 || h.Filters[0].ID != xz.FilterLZMA2 {
+				t.Fatalf("block %d: header %+v does not match %+v", i, h, b)
+			}
+			var got []byte
+			if partial {
+				// the rest of the block is discarded
+				got = make([]byte, b.UncompressedSize/2)
+				_, err = io.ReadFull(z, got)
+			} else {
+				got, err = ioutil.ReadAll(z)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want[b.UncompressedOffset:][:len(got)]) ||
+				!partial && int64(len(got)) != b.UncompressedSize {
+				t.Fatalf("block %d: data differs", i)
+			}
+		}
+	}
+}
+
+func TestNextBlockFilters(t *testing.T) {
+	data, err := readTestFile("good-1-x86-lzma2.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := xz.NewReader(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := z.NextBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Filters) != 2 || h.Filters[0].ID != xz.FilterX86 ||
+		h.Filters[1].ID != xz.FilterLZMA2 {
+		t.Fatalf("unexpected filters: %+v", h.Filters)
+	}
+	if _, err = io.Copy(ioutil.Discard, z); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.NextBlock(); err != io.EOF {
+		t.Fatalf("wanted error: %v, got: %v", io.EOF, err)
+	}
+}
+
+func TestBlockHeaderHook(t *testing.T) {
+	for _, test := range []struct {
+		file     string
+		dictSize uint32
+		filters  string
+	}{
+		// as shown by "xz -lvv" from XZ Utils
+		{"good-1-x86-lzma2.xz", 1 << 16, "[x86 LZMA2 dict=64KiB]"},
+		{"good-1-x86-lzma2-offset-2048.xz", 1 << 26,
+			"[x86 start=2048 LZMA2 dict=64MiB]"},
+		{"good-1-arm64-lzma2-offset-2048.xz", 1 << 23,
+			"[ARM64 start=2048 LZMA2 dict=8MiB]"},
+		{"good-1-delta-lzma2.tiff.xz", 1 << 20,
+			"[Delta dist=3 LZMA2 dict=1MiB]"},
+		{"good-1-lzma2-1.xz", 1 << 16, "[LZMA2 dict=64KiB]"},
+	} {
+		data, err := readTestFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var headers []*xz.BlockHeader
+		z, err := xz.NewReaderOptions(bytes.NewReader(data),
+			&xz.ReaderOptions{BlockHeader: func(h *xz.BlockHeader) {
+				headers = append(headers, h)
+			}})
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, z)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(headers) != 1 {
+			t.Fatalf("%s: wanted 1 header, got %d", test.file, len(headers))
+		}
+		h := headers[0]
+		if h.DictSize != test.dictSize ||
+			fmt.Sprint(h.Filters) != test.filters {
+			t.Fatalf("%s: wanted %d %s, got %d %v", test.file,
+				test.dictSize, test.filters, h.DictSize, h.Filters)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ * xzgo --list
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/xi2/xz"
+)
+
+/*
+ * The output of --list follows that of "xz --list" from XZ Utils,
+ * as a table with a line per file or, with --verbose, a description
+ * of each file with tables of its streams and blocks.
+ */
+
+// errListStdin is returned when listing standard input.
+var errListStdin = errors.New("--list does not support reading from standard input")
+
+// listTotals accumulates the information about a set of files.
+type listTotals struct {
+	files        int
+	streams      int
+	blocks       int
+	compressed   int64
+	uncompressed int64
+	padding      int64
+	checks       uint16 // bit n set if check type n is used
+}
+
+// add adds the information about the file described by idx to t.
+func (t *listTotals) add(idx *xz.Index) {
+	t.files++
+	t.streams += len(idx.Streams)
+	for _, st := range idx.Streams {
+		t.blocks += len(st.Blocks)
+		t.padding += st.Padding
+		t.checks |= 1 << uint(st.CheckType)
+	}
+	t.compressed += idx.CompressedSize
+	t.uncompressed += idx.UncompressedSize
+}
+
+// checkName returns the name used by XZ Utils for the check type id.
+func checkName(id xz.CheckID) string {
+	switch id {
+	case xz.CheckNone, xz.CheckCRC32, xz.CheckCRC64:
+		return id.String()
+	case xz.CheckSHA256:
+		return "SHA-256"
+	}
+	return fmt.Sprintf("Unknown-%d", id)
+}
+
+// checkNames returns the names of the check types set in checks,
+// separated by sep.
+func checkNames(checks uint16, sep string) string {
+	var names []string
+	for id := 0; id < 16; id++ {
+		if checks&(1<<uint(id)) != 0 {
+			names = append(names, checkName(xz.CheckID(id)))
+		}
+	}
+	return strings.Join(names, sep)
+}
+
+// sizeStr formats size as XZ Utils does, in bytes if it is small and
+// otherwise in the smallest binary unit giving at most four digits
+// before the decimal point. If exact is true the size in bytes is
+// added to larger values.
+func sizeStr(size int64, exact bool) string {
+	if size < 10000 {
+		return fmt.Sprintf("%d B", size)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	d := float64(size) / 1024
+	u := 0
+	for d > 9999.9 && u < len(units)-1 {
+		d /= 1024
+		u++
+	}
+	s := fmt.Sprintf("%.1f %s", d, units[u])
+	if exact {
+		s += fmt.Sprintf(" (%d B)", size)
+	}
+	return s
+}
+
+// ratioStr returns the compression ratio of compressed to
+// uncompressed, or "---" if there is none or it is too large to show.
+func ratioStr(compressed, uncompressed int64) string {
+	if uncompressed == 0 {
+		return "---"
+	}
+	r := float64(compressed) / float64(uncompressed)
+	if r > 9.999 {
+		return "---"
+	}
+	return fmt.Sprintf("%.3f", r)
+}
+
+const (
+	listHeader   = "Strms  Blocks   Compressed Uncompressed  Ratio  Check   Filename\n"
+	listRow      = "%5d %7d  %11s  %11s  %5s  %-7s %s\n"
+	listDivider  = "-------------------------------------------------------------------------------\n"
+	listDetail   = "  %-19s%s\n"
+	listStreams  = "    %6s %9s %15s %15s %15s %15s  %5s  %-7s %10s\n"
+	listStreamsN = "    %6d %9d %15d %15d %15d %15d  %5s  %-7s %*d\n"
+	listBlocks   = "    %6s %9s %15s %15s %15s %15s  %5s  %s\n"
+	listBlocksN  = "    %6d %9d %15d %15d %15d %15d  %5s  %s\n"
+)
+
+// listFile writes a line describing the file name, or with verbose
+// a description of it, the n'th of count files.
+func listFile(w io.Writer, name string, idx *xz.Index, n, count int, verbose bool) {
+	var t listTotals
+	t.add(idx)
+	if !verbose {
+		fmt.Fprintf(w, listRow, t.streams, t.blocks,
+			sizeStr(t.compressed, false), sizeStr(t.uncompressed, false),
+			ratioStr(t.compressed, t.uncompressed),
+			checkNames(t.checks, ","), name)
+		return
+	}
+	if n > 1 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s (%d/%d)\n", name, n, count)
+	listDetails(w, &t)
+	fmt.Fprintf(w, "  Streams:\n")
+	fmt.Fprintf(w, listStreams, "Stream", "Blocks", "CompOffset",
+		"UncompOffset", "CompSize", "UncompSize", "Ratio", "Check",
+		"Padding")
+	for i, st := range idx.Streams {
+		// a long check name takes space from the padding column
+		check := checkName(st.CheckType)
+		width := 10
+		if len(check) > 7 {
+			width -= len(check) - 7
+		}
+		fmt.Fprintf(w, listStreamsN, i+1, len(st.Blocks), st.Offset,
+			st.UncompressedOffset, st.CompressedSize,
+			st.UncompressedSize,
+			ratioStr(st.CompressedSize, st.UncompressedSize),
+			check, width, st.Padding)
+	}
+	if t.blocks == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  Blocks:\n")
+	fmt.Fprintf(w, listBlocks, "Stream", "Block", "CompOffset",
+		"UncompOffset", "TotalSize", "UncompSize", "Ratio", "Check")
+	block := 0
+	for i, st := range idx.Streams {
+		for _, b := range st.Blocks {
+			block++
+			fmt.Fprintf(w, listBlocksN, i+1, block, b.Offset,
+				b.UncompressedOffset, b.CompressedSize,
+				b.UncompressedSize,
+				ratioStr(b.CompressedSize, b.UncompressedSize),
+				checkName(st.CheckType))
+		}
+	}
+}
+
+// listDetails writes the verbose description of t shared by files
+// and totals.
+func listDetails(w io.Writer, t *listTotals) {
+	fmt.Fprintf(w, listDetail, "Streams:", fmt.Sprint(t.streams))
+	fmt.Fprintf(w, listDetail, "Blocks:", fmt.Sprint(t.blocks))
+	fmt.Fprintf(w, listDetail, "Compressed size:", sizeStr(t.compressed, true))
+	fmt.Fprintf(w, listDetail, "Uncompressed size:", sizeStr(t.uncompressed, true))
+	fmt.Fprintf(w, listDetail, "Ratio:", ratioStr(t.compressed, t.uncompressed))
+	fmt.Fprintf(w, listDetail, "Check:", checkNames(t.checks, ", "))
+	fmt.Fprintf(w, listDetail, "Stream Padding:", sizeStr(t.padding, true))
+}
+
+// listTotal writes the totals for the files listed.
+func listTotal(w io.Writer, t *listTotals, verbose bool) {
+	if !verbose {
+		fmt.Fprint(w, listDivider)
+		fmt.Fprintf(w, listRow, t.streams, t.blocks,
+			sizeStr(t.compressed, false), sizeStr(t.uncompressed, false),
+			ratioStr(t.compressed, t.uncompressed),
+			checkNames(t.checks, ","), fmt.Sprintf("%d files", t.files))
+		return
+	}
+	fmt.Fprintf(w, "\nTotals:\n")
+	fmt.Fprintf(w, listDetail, "Number of files:", fmt.Sprint(t.files))
+	listDetails(w, t)
+}
+
+// readIndex returns the Index of the XZ file name.
+func readIndex(name string) (*xz.Index, error) {
+	if name == "-" {
+		return nil, errListStdin
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, errNotRegular
+	}
+	return xz.ReadIndex(f, fi.Size())
+}
+
+// listFiles writes the information about files to w, reporting errors
+// to standard error. It returns false if any file could not be
+// listed.
+func listFiles(w io.Writer, files []string, verbose bool) bool {
+	var t listTotals
+	ok := true
+	for i, name := range files {
+		idx, err := readIndex(name)
+		if err != nil {
+			warn(name, err)
+			ok = false
+			continue
+		}
+		if t.files == 0 && !verbose {
+			fmt.Fprint(w, listHeader)
+		}
+		t.add(idx)
+		listFile(w, name, idx, i+1, len(files), verbose)
+	}
+	if t.files > 1 {
+		listTotal(w, &t, verbose)
+	}
+	return ok
+}
@@ -0,0 +1,244 @@
+/*
+ * xzgo command
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+// Command xzgo decompresses, tests and lists XZ files. It accepts a
+// subset of the options of the xz program of XZ Utils:
+//
+//	xzgo [-d] [-c] [-k] [-f] [-M limit] [file...]
+//	xzgo -t [-M limit] [file...]
+//	xzgo -l [-v] file...
+//
+// With no file, or when file is -, standard input is decompressed to
+// standard output. Otherwise each file.xz (or file.txz) is
+// decompressed to file (or file.tar), which is removed unless -k or
+// -c is given.
+//
+// The memory usage limit set with -M bounds the LZMA2 dictionary size
+// of the decompressor. It is a number of bytes with an optional KiB,
+// MiB or GiB suffix, or 0 or max for no limit beyond that of the
+// format.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xi2/xz"
+)
+
+// Command line options
+var (
+	decompress bool
+	test       bool
+	list       bool
+	stdout     bool
+	keep       bool
+	force      bool
+	verbose    bool
+	memlimit   string
+)
+
+func init() {
+	for _, f := range []struct {
+		p           *bool
+		short, long string
+		usage       string
+	}{
+		{&decompress, "d", "decompress", "decompress (the default)"},
+		{&test, "t", "test", "test compressed file integrity"},
+		{&list, "l", "list", "list information about .xz files"},
+		{&stdout, "c", "stdout", "write to standard output and don't delete input files"},
+		{&keep, "k", "keep", "keep (don't delete) input files"},
+		{&force, "f", "force", "force overwrite of output files"},
+		{&verbose, "v", "verbose", "be verbose when listing files"},
+	} {
+		flag.BoolVar(f.p, f.short, false, f.usage)
+		flag.BoolVar(f.p, f.long, false, "same as -"+f.short)
+	}
+	flag.StringVar(&memlimit, "M", "", "set the memory usage `limit` for decompression")
+	flag.StringVar(&memlimit, "memlimit", "", "same as -M")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: xzgo [option]... [file]...\n")
+		flag.PrintDefaults()
+	}
+}
+
+// errUnknownSuffix is returned for files which would be decompressed
+// to a file but whose name does not end in a known suffix.
+var errUnknownSuffix = errors.New("Filename has an unknown suffix, skipping")
+
+// errNotRegular is returned for files which are not regular files.
+var errNotRegular = errors.New("Not a regular file, skipping")
+
+// suffixes maps the suffixes of compressed files to those of the
+// decompressed files.
+var suffixes = []struct{ compressed, uncompressed string }{
+	{".xz", ""},
+	{".txz", ".tar"},
+}
+
+// warn prints a message about file to standard error.
+func warn(file string, err error) {
+	msg := strings.TrimPrefix(err.Error(), "xz: ")
+	if file == "" {
+		fmt.Fprintf(os.Stderr, "xzgo: %s\n", msg)
+	} else {
+		fmt.Fprintf(os.Stderr, "xzgo: %s: %s\n", file, msg)
+	}
+}
+
+// parseMemlimit returns the memory usage limit s in bytes, or zero
+// if there is no limit.
+func parseMemlimit(s string) (uint64, error) {
+	if s == "0" || s == "max" {
+		return 0, nil
+	}
+	num, mult := s, uint64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   uint64
+	}{
+		{"KiB", 1 << 10}, {"Ki", 1 << 10}, {"k", 1 << 10}, {"K", 1 << 10},
+		{"MiB", 1 << 20}, {"Mi", 1 << 20}, {"M", 1 << 20},
+		{"GiB", 1 << 30}, {"Gi", 1 << 30}, {"G", 1 << 30},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			num = strings.TrimSuffix(s, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("%s: invalid memory usage limit", s)
+	}
+	if n > math.MaxUint64/mult {
+		return 0, nil
+	}
+	return n * mult, nil
+}
+
+// outputName returns the name of the file to which file is
+// decompressed.
+func outputName(file string) (string, error) {
+	for _, s := range suffixes {
+		if len(file) > len(s.compressed) &&
+			strings.HasSuffix(file, s.compressed) {
+			return strings.TrimSuffix(file, s.compressed) +
+				s.uncompressed, nil
+		}
+	}
+	return "", errUnknownSuffix
+}
+
+// decompressFile decompresses, or with -t tests, file using the
+// Reader options opts.
+func decompressFile(file string, opts *xz.ReaderOptions) (err error) {
+	in := os.Stdin
+	if file != "-" {
+		if in, err = os.Open(file); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	var w io.Writer
+	var out *os.File
+	switch {
+	case test:
+		w = ioutil.Discard
+	case stdout || file == "-":
+		w = os.Stdout
+	default:
+		var name string
+		var fi os.FileInfo
+		if name, err = outputName(file); err != nil {
+			return err
+		}
+		if fi, err = in.Stat(); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return errNotRegular
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if force {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		if out, err = os.OpenFile(name, flags, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				_ = os.Remove(name)
+				return
+			}
+			_ = os.Chtimes(name, fi.ModTime(), fi.ModTime())
+			if !keep {
+				err = os.Remove(file)
+			}
+		}()
+		w = out
+	}
+	r, err := xz.NewReaderOptions(in, opts)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func main() {
+	flag.Parse()
+	if test && list {
+		warn("", errors.New("only one of --test and --list may be given"))
+		os.Exit(1)
+	}
+	opts := &xz.ReaderOptions{}
+	if memlimit != "" {
+		var err error
+		if opts.MemLimit, err = parseMemlimit(memlimit); err != nil {
+			warn("", err)
+			os.Exit(1)
+		}
+		// the dictionary size is limited only by the memory limit
+		opts.DictMax = math.MaxUint32
+	}
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status := 0
+	if list {
+		if !listFiles(os.Stdout, files, verbose) {
+			status = 1
+		}
+		os.Exit(status)
+	}
+	for _, file := range files {
+		if err := decompressFile(file, opts); err != nil {
+			name := file
+			if name == "-" {
+				name = "(stdin)"
+			}
+			warn(name, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
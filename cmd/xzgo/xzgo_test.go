@@ -0,0 +1,103 @@
+/*
+ * xzgo tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMemlimit(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want uint64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"max", 0, true},
+		{"65536", 65536, true},
+		{"64KiB", 64 << 10, true},
+		{"8MiB", 8 << 20, true},
+		{"8M", 8 << 20, true},
+		{"3GiB", 3 << 30, true},
+		{"8GiB", 8 << 30, true},
+		{"", 0, false},
+		{"MiB", 0, false},
+		{"8TiB", 0, false},
+		{"-1", 0, false},
+	} {
+		got, err := parseMemlimit(test.s)
+		if (err == nil) != test.ok || got != test.want {
+			t.Fatalf("%q: wanted %d, %v, got %d, %v",
+				test.s, test.want, test.ok, got, err)
+		}
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	for _, test := range []struct {
+		file string
+		want string
+	}{
+		{"a.xz", "a"},
+		{"dir/a.txz", "dir/a.tar"},
+		{"a.tar.xz", "a.tar"},
+		{".xz", ""},
+		{"a.lzma", ""},
+	} {
+		got, err := outputName(test.file)
+		if got != test.want || (err != nil) != (test.want == "") {
+			t.Fatalf("%q: wanted %q, got %q, %v",
+				test.file, test.want, got, err)
+		}
+	}
+}
+
+func TestSizeStr(t *testing.T) {
+	for _, test := range []struct {
+		size  int64
+		exact bool
+		want  string
+	}{
+		{0, true, "0 B"},
+		{9999, true, "9999 B"},
+		{47444, false, "46.3 KiB"},
+		{47444, true, "46.3 KiB (47444 B)"},
+		{100000000, false, "95.4 MiB"},
+		{10238976, false, "9999.0 KiB"},
+		{10240000, false, "9.8 MiB"},
+	} {
+		if got := sizeStr(test.size, test.exact); got != test.want {
+			t.Fatalf("%d: wanted %q, got %q", test.size, test.want, got)
+		}
+	}
+}
+
+// The expected output is that of "xz --list" from XZ Utils.
+func TestListFiles(t *testing.T) {
+	files := []string{
+		filepath.Join("..", "..", "testdata", "other", "words-blocks.xz"),
+		filepath.Join("..", "..", "testdata", "xz-utils", "good-0cat-empty.xz"),
+	}
+	want := "" +
+		"Strms  Blocks   Compressed Uncompressed  Ratio  Check   Filename\n" +
+		"    1       6     46.3 KiB     87.3 KiB  0.531  CRC32   " + files[0] + "\n" +
+		"    2       0         64 B          0 B    ---  CRC32   " + files[1] + "\n" +
+		"-------------------------------------------------------------------------------\n" +
+		"    3       6     46.4 KiB     87.3 KiB  0.531  CRC32   2 files\n"
+	var buf bytes.Buffer
+	if !listFiles(&buf, files, false) {
+		t.Fatal("listFiles failed")
+	}
+	if buf.String() != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
@@ -47,6 +47,7 @@ type xzDecBCJ struct {
 		 * ARM              4           0
 		 * ARM-Thumb        2           2
 		 * SPARC            4           0
+		 * ARM64            4           0
 		 */
 		buf      []byte // slice buf will be backed by bufArray
 		bufArray [16]byte
@@ -264,6 +265,35 @@ func bcjSPARCFilter(s *xzDecBCJ, buf []byte) int {
 	return i
 }
 
+func bcjARM64Filter(s *xzDecBCJ, buf []byte) int {
+	var i int
+	var instr uint32
+	var addr uint32
+	for i = 0; i+4 <= len(buf); i += 4 {
+		instr = getLE32(buf[i:])
+		if instr>>26 == 0x25 {
+			/* BL instruction */
+			addr = instr - uint32(s.pos+i)>>2
+			instr = 0x94000000 | addr&0x03FFFFFF
+			putLE32(instr, buf[i:])
+		} else if instr&0x9F000000 == 0x90000000 {
+			/* ADRP instruction */
+			addr = (instr>>29)&3 | (instr>>3)&0x1FFFFC
+			/* Only convert values in the range +/-512 MiB. */
+			if (addr+0x020000)&0x1C0000 != 0 {
+				continue
+			}
+			addr -= uint32(s.pos+i) >> 12
+			instr &= 0x9000001F
+			instr |= (addr & 3) << 29
+			instr |= (addr & 0x03FFFC) << 3
+			instr |= (0 - addr&0x020000) & 0xE00000
+			putLE32(instr, buf[i:])
+		}
+	}
+	return i
+}
+
 /*
  * Apply the selected BCJ filter. Update *pos and s.pos to match the amount
  * of data that got filtered.
@@ -284,6 +314,8 @@ func bcjApply(s *xzDecBCJ, buf []byte, pos *int) {
 		filtered = bcjARMThumbFilter(s, buf)
 	case idBCJSPARC:
 		filtered = bcjSPARCFilter(s, buf)
+	case idBCJARM64:
+		filtered = bcjARM64Filter(s, buf)
 	default:
 		/* Never reached */
 	}
@@ -420,11 +452,12 @@ func xzDecBCJCreate() *xzDecBCJ {
 }
 
 /*
- * Decode the Filter ID of a BCJ filter and check the start offset is
- * valid. Returns xzOK if the given Filter ID and offset is
- * supported. Otherwise xzOptionsError is returned.
+ * Check that id is the Filter ID of a BCJ filter and that the start
+ * offset is a multiple of the filter's alignment. Returns xzOK if the
+ * given Filter ID and offset is supported. Otherwise xzOptionsError is
+ * returned.
  */
-func xzDecBCJReset(s *xzDecBCJ, id xzFilterID, offset int) xzRet {
+func bcjCheck(id xzFilterID, offset int) xzRet {
 	switch id {
 	case idBCJX86:
 	case idBCJPowerPC:
@@ -432,13 +465,14 @@ func xzDecBCJReset(s *xzDecBCJ, id xzFilterID, offset int) xzRet {
 	case idBCJARM:
 	case idBCJARMThumb:
 	case idBCJSPARC:
+	case idBCJARM64:
 	default:
 		/* Unsupported Filter ID */
 		return xzOptionsError
 	}
 	// check offset is a multiple of alignment
 	switch id {
-	case idBCJPowerPC, idBCJARM, idBCJSPARC:
+	case idBCJPowerPC, idBCJARM, idBCJSPARC, idBCJARM64:
 		if offset%4 != 0 {
 			return xzOptionsError
 		}
@@ -451,6 +485,18 @@ func xzDecBCJReset(s *xzDecBCJ, id xzFilterID, offset int) xzRet {
 			return xzOptionsError
 		}
 	}
+	return xzOK
+}
+
+/*
+ * Decode the Filter ID of a BCJ filter and check the start offset is
+ * valid. Returns xzOK if the given Filter ID and offset is
+ * supported. Otherwise xzOptionsError is returned.
+ */
+func xzDecBCJReset(s *xzDecBCJ, id xzFilterID, offset int) xzRet {
+	if ret := bcjCheck(id, offset); ret != xzOK {
+		return ret
+	}
 	s.typ = id
 	s.ret = xzOK
 	s.pos = offset
@@ -0,0 +1,342 @@
+/*
+ * .xz Index decoder for random access
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"hash/crc32"
+	"io"
+)
+
+/*
+ * The functions in this file locate the Streams and Blocks of an XZ
+ * file by reading it backwards, starting with the last Stream
+ * Footer, in the same way as "xz --list" does. Nothing is
+ * decompressed.
+ */
+
+/* A record of the Index field */
+type xzIndexRecord struct {
+	unpadded     vliType
+	uncompressed vliType
+}
+
+/* Information about a Block, derived from its Index record */
+type xzIndexBlock struct {
+	/* Offset of the Block Header in the file */
+	offset int64
+	/* Offset of the Block's uncompressed data in the whole file */
+	uOffset int64
+	/* Sizes recorded in the Index */
+	unpadded     int64
+	uncompressed int64
+	/* Number of the Stream containing the Block, counting from zero */
+	stream int
+}
+
+/* Information about a Stream */
+type xzIndexStream struct {
+	/* Offset of the Stream Header in the file */
+	offset int64
+	/* Offset of the Stream's uncompressed data in the whole file */
+	uOffset int64
+	/* Size of the Stream, from Stream Header to Stream Footer */
+	compressed int64
+	/* Total uncompressed size of the Stream's Blocks */
+	uncompressed int64
+	/* Type of the Stream's integrity check */
+	checkType CheckID
+	/* Size of the Index field */
+	indexSize int64
+	/* Size of the Stream Padding following the Stream */
+	padding int64
+	/* Index records of the Stream's Blocks */
+	records []xzIndexRecord
+}
+
+/* The Streams of an XZ file and all their Blocks, in file order */
+type xzIndex struct {
+	streams []xzIndexStream
+	blocks  []xzIndexBlock
+	/* Total uncompressed size */
+	uncompressed int64
+	/* Memory counted against a memory limit for the Index */
+	memUsage uint64
+}
+
+/*
+ * Decode a variable-length integer from buf[*pos:]. Returns false if
+ * the integer is truncated, too long or not minimally encoded.
+ */
+func getVLI(buf []byte, pos *int) (vliType, bool) {
+	var vli vliType
+	for i := 0; i < vliBytesMax; i++ {
+		if *pos == len(buf) {
+			return 0, false
+		}
+		b := buf[*pos]
+		*pos++
+		vli |= vliType(b&0x7f) << uint(i*7)
+		if b&0x80 == 0 {
+			/* Don't allow non-minimal encodings. */
+			if b == 0 && i != 0 {
+				return 0, false
+			}
+			return vli, true
+		}
+	}
+	return 0, false
+}
+
+/*
+ * Decode the Stream Flags of a Stream Header or Footer, returning the
+ * check type.
+ */
+func decStreamFlags(flags []byte) (CheckID, xzRet) {
+	if flags[0] != 0 || CheckID(flags[1]) > checkMax {
+		return 0, xzOptionsError
+	}
+	return CheckID(flags[1]), xzOK
+}
+
+/* Decode a Stream Header held in buf. */
+func decStreamHeaderBuf(buf []byte) (CheckID, xzRet) {
+	if string(buf[:len(headerMagic)]) != headerMagic {
+		return 0, xzFormatError
+	}
+	if crc32.ChecksumIEEE(buf[len(headerMagic):len(headerMagic)+2]) !=
+		getLE32(buf[len(headerMagic)+2:]) {
+		return 0, xzDataError
+	}
+	return decStreamFlags(buf[len(headerMagic):])
+}
+
+/*
+ * Decode a Stream Footer held in buf. Returns the check type and the
+ * size of the Index field.
+ */
+func decStreamFooterBuf(buf []byte) (CheckID, int64, xzRet) {
+	if string(buf[10:10+len(footerMagic)]) != footerMagic {
+		return 0, 0, xzFormatError
+	}
+	if crc32.ChecksumIEEE(buf[4:10]) != getLE32(buf) {
+		return 0, 0, xzDataError
+	}
+	checkType, ret := decStreamFlags(buf[8:])
+	return checkType, (int64(getLE32(buf[4:])) + 1) * 4, ret
+}
+
+/*
+ * Decode an Index field held in buf, which must contain exactly the
+ * Index field (including Index Padding and CRC32).
+ */
+func decIndexBuf(buf []byte) ([]xzIndexRecord, xzRet) {
+	if len(buf) < 8 || buf[0] != 0 {
+		return nil, xzDataError
+	}
+	if crc32.ChecksumIEEE(buf[:len(buf)-4]) != getLE32(buf[len(buf)-4:]) {
+		return nil, xzDataError
+	}
+	buf = buf[:len(buf)-4]
+	pos := 1
+	count, ok := getVLI(buf, &pos)
+	/* Each record takes at least two bytes */
+	if !ok || count > vliType(len(buf)-pos)/2 {
+		return nil, xzDataError
+	}
+	records := make([]xzIndexRecord, count)
+	for i := range records {
+		unpadded, ok1 := getVLI(buf, &pos)
+		uncompressed, ok2 := getVLI(buf, &pos)
+		/*
+		 * Unpadded Size must be at least five (the smallest Block
+		 * Header plus one byte of Compressed Data) and it must
+		 * leave the Block smaller than 2^63 bytes.
+		 */
+		if !ok1 || !ok2 || unpadded < 5 || unpadded > 1<<63-4 ||
+			uncompressed > 1<<63-1 {
+			return nil, xzDataError
+		}
+		records[i] = xzIndexRecord{
+			unpadded:     unpadded,
+			uncompressed: uncompressed,
+		}
+	}
+	/* The rest must be Index Padding. */
+	if len(buf)-pos > 3 || len(buf)&3 != 0 {
+		return nil, xzDataError
+	}
+	for ; pos < len(buf); pos++ {
+		if buf[pos] != 0 {
+			return nil, xzDataError
+		}
+	}
+	return records, xzOK
+}
+
+/*
+ * Read buf from r at offset off, returning xzBufError if the data
+ * isn't all there. Other read errors are returned as err.
+ */
+func readFullAt(r io.ReaderAt, buf []byte, off int64) (xzRet, error) {
+	n, err := r.ReadAt(buf, off)
+	if n == len(buf) {
+		return xzOK, nil
+	}
+	if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return xzBufError, nil
+	}
+	return xzOK, err
+}
+
+/*
+ * Count the bytes of Stream Padding which end at offset end. Stream
+ * Padding comes in multiples of four bytes.
+ */
+func decStreamPadding(r io.ReaderAt, end int64) (int64, xzRet, error) {
+	var buf [1024]byte
+	var padding int64
+	for end-padding > 0 {
+		n := int64(len(buf))
+		if n > end-padding {
+			n = end - padding
+		}
+		b := buf[:n]
+		if ret, err := readFullAt(r, b, end-padding-n); ret != xzOK || err != nil {
+			return 0, ret, err
+		}
+		for i := len(b) - 4; i >= 0; i -= 4 {
+			if getLE32(b[i:]) != 0 {
+				return padding, xzOK, nil
+			}
+			padding += 4
+		}
+	}
+	return padding, xzOK, nil
+}
+
+/*
+ * Decode the Index of every Stream in the first size bytes of r,
+ * working backwards from the end. The Stream Headers are checked
+ * too, but the Blocks themselves are not read. If memLimit is not
+ * zero and the decoded Index, or the Index field being read, would
+ * need more memory than that, xzMemlimitError is returned.
+ */
+func decFileIndex(r io.ReaderAt, size int64, memLimit uint64) (*xzIndex, xzRet, error) {
+	if size < 2*streamHeaderSize {
+		return nil, xzFormatError, nil
+	}
+	/* The total size of valid XZ files is a multiple of four. */
+	if size&3 != 0 {
+		return nil, xzDataError, nil
+	}
+	var streams []xzIndexStream
+	var buf [streamHeaderSize]byte
+	var memUsage uint64
+	pos := size
+	for pos > 0 {
+		var st xzIndexStream
+		padding, ret, err := decStreamPadding(r, pos)
+		if ret != xzOK || err != nil {
+			return nil, ret, err
+		}
+		/* There must be a Stream before any Stream Padding. */
+		if padding == pos {
+			return nil, xzDataError, nil
+		}
+		st.padding = padding
+		pos -= padding
+		if pos < 2*streamHeaderSize {
+			return nil, xzDataError, nil
+		}
+		/* Stream Footer */
+		if ret, err = readFullAt(r, buf[:], pos-streamHeaderSize); ret != xzOK || err != nil {
+			return nil, ret, err
+		}
+		st.checkType, st.indexSize, ret = decStreamFooterBuf(buf[:])
+		if ret != xzOK {
+			return nil, ret, nil
+		}
+		if st.indexSize > pos-2*streamHeaderSize {
+			return nil, xzDataError, nil
+		}
+		/* Index */
+		if memLimit != 0 && memUsage+uint64(st.indexSize) > memLimit {
+			return nil, xzMemlimitError, nil
+		}
+		indexPos := pos - streamHeaderSize - st.indexSize
+		index := make([]byte, st.indexSize)
+		if ret, err = readFullAt(r, index, indexPos); ret != xzOK || err != nil {
+			return nil, ret, err
+		}
+		if st.records, ret = decIndexBuf(index); ret != xzOK {
+			return nil, ret, nil
+		}
+		memUsage += memUsageIndexStream +
+			uint64(len(st.records))*memUsageIndexBlock
+		if memLimit != 0 && memUsage > memLimit {
+			return nil, xzMemlimitError, nil
+		}
+		var blocksSize int64
+		for _, rec := range st.records {
+			blocksSize += (int64(rec.unpadded) + 3) &^ 3
+			st.uncompressed += int64(rec.uncompressed)
+			if blocksSize > indexPos || st.uncompressed < 0 {
+				return nil, xzDataError, nil
+			}
+		}
+		/* Stream Header */
+		st.offset = indexPos - blocksSize - streamHeaderSize
+		if st.offset < 0 {
+			return nil, xzDataError, nil
+		}
+		if ret, err = readFullAt(r, buf[:], st.offset); ret != xzOK || err != nil {
+			return nil, ret, err
+		}
+		checkType, ret := decStreamHeaderBuf(buf[:])
+		if ret != xzOK {
+			return nil, ret, nil
+		}
+		if checkType != st.checkType {
+			return nil, xzDataError, nil
+		}
+		st.compressed = pos - st.offset
+		streams = append(streams, st)
+		pos = st.offset
+	}
+	/* Reverse streams into file order and lay out the Blocks. */
+	x := &xzIndex{memUsage: memUsage}
+	count := 0
+	for _, st := range streams {
+		count += len(st.records)
+	}
+	x.blocks = make([]xzIndexBlock, 0, count)
+	x.streams = make([]xzIndexStream, 0, len(streams))
+	for i := len(streams) - 1; i >= 0; i-- {
+		st := streams[i]
+		st.uOffset = x.uncompressed
+		offset := st.offset + streamHeaderSize
+		for _, rec := range st.records {
+			x.blocks = append(x.blocks, xzIndexBlock{
+				offset:       offset,
+				uOffset:      x.uncompressed,
+				unpadded:     int64(rec.unpadded),
+				uncompressed: int64(rec.uncompressed),
+				stream:       len(x.streams),
+			})
+			offset += (int64(rec.unpadded) + 3) &^ 3
+			x.uncompressed += int64(rec.uncompressed)
+			if x.uncompressed < 0 {
+				return nil, xzDataError, nil
+			}
+		}
+		x.streams = append(x.streams, st)
+	}
+	return x, xzOK, nil
+}
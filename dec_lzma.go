@@ -0,0 +1,166 @@
+/*
+ * LZMA1 decoder
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+/*
+ * LZMA1 is the predecessor of LZMA2, used by the legacy .lzma file
+ * format (also known as LZMA_Alone). It is a single LZMA stream, not
+ * divided into chunks, so the compressed size is not known. The end
+ * of the stream is found either from the uncompressed size, when it
+ * is known, or from an end of payload marker.
+ *
+ * The LZMA2 decoder is reused. Since LZMA1 has no chunks,
+ * s.lzma2.compressed is set to a value larger than any stream until
+ * xzDecLZMA1InputEnd indicates that no more input will follow.
+ */
+
+/* Size of the .lzma file header */
+const lzmaHeaderSize = 13
+
+/* The minimum dictionary size, as in XZ Utils */
+const lzmaDictMin = 1 << 12
+
+/*
+ * Prepare s to decode an LZMA1 stream with the given properties
+ * byte, dictionary size, and uncompressed size (vliUnknown if the
+ * stream ends with an end of payload marker). Return xzOK on success,
+ * xzMemlimitError if the dictionary size exceeds the maximum given to
+ * xzDecLZMA2Create, and xzOptionsError if props is not supported.
+ */
+func xzDecLZMA1Reset(s *xzDecLZMA2, props byte, dictSize uint32,
+	uncompressed vliType) xzRet {
+	if dictSize < lzmaDictMin {
+		dictSize = lzmaDictMin
+	}
+	if dictSize > s.dict.sizeMax {
+		return xzMemlimitError
+	}
+	if !lzmaProps(s, props) {
+		return xzOptionsError
+	}
+	s.dict.size = dictSize
+	s.dict.end = dictSize
+	if len(s.dict.buf) < int(s.dict.size) {
+		s.dict.buf = make([]byte, s.dict.size)
+	}
+	dictReset(&s.dict, nil)
+	s.lzma.len = 0
+	s.lzma2.sequence = seqLZMAPrepare
+	/* Leave room for lzma2LZMA to add lzmaInRequired without overflow */
+	s.lzma2.compressed = int(^uint(0)>>1) - lzmaInRequired
+	s.lzma2.uncompressed = 0
+	s.lzma2.needDictReset = false
+	s.lzma2.needProps = false
+	s.temp.buf = nil
+	s.lzma1.uncompressed = uncompressed
+	s.lzma1.inputEnd = false
+	return xzOK
+}
+
+/*
+ * Indicate that all of the input has been passed to xzDecLZMA1Run.
+ * This allows the last few bytes of the stream, which are held back
+ * until more input arrives, to be decoded.
+ */
+func xzDecLZMA1InputEnd(s *xzDecLZMA2) {
+	if s.lzma2.sequence == seqLZMARun && !s.lzma1.inputEnd {
+		s.lzma2.compressed = len(s.temp.buf)
+		s.lzma1.inputEnd = true
+	}
+}
+
+/*
+ * Return the input bytes held by s which follow the end of the LZMA1
+ * stream. After xzDecLZMA1Run returns xzStreamEnd, the input which
+ * follows the stream is these bytes followed by those remaining in
+ * the input buffer.
+ */
+func xzDecLZMA1Unused(s *xzDecLZMA2) []byte {
+	return s.temp.buf
+}
+
+/*
+ * Return the error for corrupt input, which after the end of input
+ * may instead be truncated.
+ */
+func lzma1Error(s *xzDecLZMA2) xzRet {
+	if s.lzma1.inputEnd {
+		return xzBufError
+	}
+	return xzDataError
+}
+
+/*
+ * Decode an LZMA1 stream. Returns xzStreamEnd at the end of the
+ * stream, xzOK if more input or output space is needed, xzDataError
+ * if the input is corrupt, and xzBufError if the input ended before
+ * the end of the stream.
+ */
+func xzDecLZMA1Run(s *xzDecLZMA2, b *xzBuf) xzRet {
+	if s.lzma2.sequence == seqLZMAPrepare {
+		if !rcReadInit(&s.rc, b) {
+			return xzOK
+		}
+		s.lzma2.sequence = seqLZMARun
+	}
+	for {
+		if s.lzma.endMarker {
+			if s.lzma1.uncompressed != vliUnknown &&
+				s.lzma1.uncompressed != 0 || !rcIsFinished(&s.rc) {
+				return xzDataError
+			}
+			return xzStreamEnd
+		}
+		if s.lzma1.uncompressed == 0 {
+			if s.lzma.len > 0 {
+				return xzDataError
+			}
+			if rcIsFinished(&s.rc) {
+				return xzStreamEnd
+			}
+		}
+		/* Wait for more input unless it has all been given. */
+		if b.inPos == len(b.in) && len(s.temp.buf) < s.lzma2.compressed {
+			return xzOK
+		}
+		if s.lzma1.uncompressed == 0 {
+			/*
+			 * Even when the uncompressed size is known, the
+			 * stream may end with an end of payload marker.
+			 * Allow one more symbol to be decoded, which must
+			 * be the marker.
+			 */
+			pos := s.dict.pos
+			dictLimit(&s.dict, 1)
+			if !lzma2LZMA(s, b) {
+				return lzma1Error(s)
+			}
+			if s.dict.pos != pos {
+				return xzDataError
+			}
+			continue
+		}
+		if b.outPos == len(b.out) {
+			return xzOK
+		}
+		outMax := len(b.out) - b.outPos
+		if s.lzma1.uncompressed < vliType(outMax) {
+			outMax = int(s.lzma1.uncompressed)
+		}
+		dictLimit(&s.dict, outMax)
+		if !lzma2LZMA(s, b) {
+			return lzma1Error(s)
+		}
+		n := dictFlush(&s.dict, b)
+		if s.lzma1.uncompressed != vliUnknown {
+			s.lzma1.uncompressed -= vliType(n)
+		}
+	}
+}
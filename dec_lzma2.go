@@ -262,6 +262,21 @@ type dictionary struct {
 	size uint32
 	/* Maximum allowed dictionary size. */
 	sizeMax uint32
+	/* If not nil, the pool from which buf is taken. */
+	pool *DictPool
+	/*
+	 * True in single-call mode, where the output buffer is used as
+	 * the dictionary and no dictionary buffer is allocated. The
+	 * output buffer must then be big enough to hold all the
+	 * uncompressed data.
+	 */
+	single bool
+	/*
+	 * True if buf is the output buffer, as set up by dictReset in
+	 * single-call mode, rather than a buffer owned by the
+	 * dictionary. It must not be reused once the block ends.
+	 */
+	output bool
 }
 
 /* Range decoder */
@@ -318,6 +333,11 @@ type lzmaDec struct {
 	lc             uint32
 	literalPosMask uint32
 	posMask        uint32
+	/*
+	 * True once the end of payload marker has been decoded. The
+	 * marker is only allowed in LZMA1 streams.
+	 */
+	endMarker bool
 	/* If 1, it's a match. Otherwise it's a single 8-bit literal. */
 	isMatch [states][posStatesMax]uint16
 	/* If 1, it's a repeated match. The distance is one of rep0 .. rep3. */
@@ -424,6 +444,18 @@ type xzDecLZMA2 struct {
 		buf      []byte // slice buf will be backed by bufArray
 		bufArray [3 * lzmaInRequired]byte
 	}
+	/* State used only when decoding LZMA1. See dec_lzma.go. */
+	lzma1 struct {
+		/*
+		 * Uncompressed size remaining, or vliUnknown if the
+		 * stream ends with an end of payload marker
+		 */
+		uncompressed vliType
+		/* True once xzDecLZMA1InputEnd has been called */
+		inputEnd bool
+	}
+	/* Description of the last error returned */
+	reason string
 }
 
 /**************
@@ -435,12 +467,43 @@ type xzDecLZMA2 struct {
  * of the dictionary to point to the actual output buffer.
  */
 func dictReset(dict *dictionary, b *xzBuf) {
+	if dict.single {
+		dict.buf = b.out[b.outPos:]
+		dict.end = uint32(len(dict.buf))
+		dict.output = true
+	}
 	dict.start = 0
 	dict.pos = 0
 	dict.limit = 0
 	dict.full = 0
 }
 
+/*
+ * Replace the dictionary buffer with a new one of dict.size bytes,
+ * returning the old buffer to dict.pool if there is one.
+ */
+func dictAlloc(dict *dictionary) {
+	if dict.pool == nil {
+		dict.buf = make([]byte, dict.size)
+		return
+	}
+	dict.pool.put(dict.buf)
+	dict.buf = dict.pool.get(int(dict.size))
+}
+
+/*
+ * Release the dictionary buffer, returning it to dict.pool if there
+ * is one and buf is not the output buffer. A new buffer is allocated
+ * when the decoder is next reset.
+ */
+func dictFree(dict *dictionary) {
+	if dict.pool != nil && !dict.output {
+		dict.pool.put(dict.buf)
+	}
+	dict.buf = nil
+	dict.output = false
+}
+
 /* Set dictionary write limit */
 func dictLimit(dict *dictionary, outMax int) {
 	if dict.end-dict.pos <= uint32(outMax) {
@@ -521,7 +584,11 @@ func dictRepeat(dict *dictionary, len *uint32, dist uint32) bool {
 	return true
 }
 
-/* Copy uncompressed data as is from input to dictionary and output buffers. */
+/*
+ * Copy uncompressed data as is from input to dictionary and output
+ * buffers. As in dictFlush, if b.direct is set and nothing has been
+ * output yet, b.out is made to point at the data in the dictionary.
+ */
 func dictUncompressed(dict *dictionary, b *xzBuf, left *int) {
 	var copySize int
 	for *left > 0 && b.inPos < len(b.in) && b.outPos < len(b.out) {
@@ -537,14 +604,21 @@ func dictUncompressed(dict *dictionary, b *xzBuf, left *int) {
 		}
 		*left -= copySize
 		copy(dict.buf[dict.pos:], b.in[b.inPos:b.inPos+copySize])
+		switch {
+		case dict.single:
+			// the dictionary is the output buffer
+		case b.direct && b.outPos == 0:
+			b.out = dict.buf[dict.pos : dict.pos+uint32(copySize)]
+		default:
+			copy(b.out[b.outPos:], b.in[b.inPos:b.inPos+copySize])
+		}
 		dict.pos += uint32(copySize)
 		if dict.full < dict.pos {
 			dict.full = dict.pos
 		}
-		if dict.pos == dict.end {
+		if dict.pos == dict.end && !dict.single {
 			dict.pos = 0
 		}
-		copy(b.out[b.outPos:], b.in[b.inPos:b.inPos+copySize])
 		dict.start = dict.pos
 		b.outPos += copySize
 		b.inPos += copySize
@@ -555,13 +629,25 @@ func dictUncompressed(dict *dictionary, b *xzBuf, left *int) {
  * Flush pending data from dictionary to b.out. It is assumed that there is
  * enough space in b.out. This is guaranteed because caller uses dictLimit
  * before decoding data into the dictionary.
+ *
+ * If b.direct is set and nothing has been output yet, b.out is instead
+ * made to point at the pending data in the dictionary. Since b.out is
+ * then full, nothing more is decoded into the dictionary until the
+ * caller has consumed the data.
  */
 func dictFlush(dict *dictionary, b *xzBuf) int {
 	var copySize int = int(dict.pos - dict.start)
-	if dict.pos == dict.end {
-		dict.pos = 0
+	if !dict.single {
+		if dict.pos == dict.end {
+			dict.pos = 0
+		}
+		if b.direct && b.outPos == 0 && copySize > 0 {
+			b.out = dict.buf[dict.start : dict.start+uint32(copySize)]
+		} else {
+			copy(b.out[b.outPos:],
+				dict.buf[dict.start:dict.start+uint32(copySize)])
+		}
 	}
-	copy(b.out[b.outPos:], dict.buf[dict.start:dict.start+uint32(copySize)])
 	dict.start = dict.pos
 	b.outPos += copySize
 	return copySize
@@ -829,7 +915,8 @@ func lzmaMain(s *xzDecLZMA2) bool {
 	 * Decode more LZMA symbols. One iteration may consume up to
 	 * lzmaInRequired - 1 bytes.
 	 */
-	for dictHasSpace(&s.dict) && !rcLimitExceeded(&s.rc) {
+	for dictHasSpace(&s.dict) && !rcLimitExceeded(&s.rc) &&
+		!s.lzma.endMarker {
 		posState = s.dict.pos & s.lzma.posMask
 		if !rcBit(&s.rc, &s.lzma.isMatch[s.lzma.state][posState]) {
 			lzmaLiteral(s)
@@ -838,6 +925,12 @@ func lzmaMain(s *xzDecLZMA2) bool {
 				lzmaRepMatch(s, posState)
 			} else {
 				lzmaMatch(s, posState)
+				/* The largest distance marks the end of payload. */
+				if s.lzma.rep0 == ^uint32(0) {
+					s.lzma.endMarker = true
+					s.lzma.len = 0
+					break
+				}
 			}
 			if !dictRepeat(&s.dict, &s.lzma.len, s.lzma.rep0) {
 				return false
@@ -858,6 +951,7 @@ func lzmaMain(s *xzDecLZMA2) bool {
  */
 func lzmaReset(s *xzDecLZMA2) {
 	s.lzma.state = stateLitLit
+	s.lzma.endMarker = false
 	s.lzma.rep0 = 0
 	s.lzma.rep1 = 0
 	s.lzma.rep2 = 0
@@ -1036,6 +1130,14 @@ func lzma2LZMA(s *xzDecLZMA2, b *xzBuf) bool {
 	return true
 }
 
+/*
+ * Record reason as the cause of the error ret, which is returned.
+ */
+func lzma2Error(s *xzDecLZMA2, ret xzRet, reason string) xzRet {
+	s.reason = reason
+	return ret
+}
+
 /*
  * Take care of the LZMA2 control layer, and forward the job of actual LZMA
  * decoding or copying of uncompressed chunks to other functions.
@@ -1087,7 +1189,8 @@ func xzDecLZMA2Run(s *xzDecLZMA2, b *xzBuf) xzRet {
 				s.lzma2.needDictReset = false
 				dictReset(&s.dict, b)
 			case s.lzma2.needDictReset:
-				return xzDataError
+				return lzma2Error(s, xzDataError,
+					"LZMA2 missing dictionary reset")
 			}
 			if tmp >= 0x80 {
 				s.lzma2.uncompressed = (tmp & 0x1f) << 16
@@ -1102,7 +1205,8 @@ func xzDecLZMA2Run(s *xzDecLZMA2, b *xzBuf) xzRet {
 					s.lzma2.needProps = false
 					s.lzma2.nextSequence = seqProperties
 				case s.lzma2.needProps:
-					return xzDataError
+					return lzma2Error(s, xzDataError,
+						"LZMA2 missing properties")
 				default:
 					s.lzma2.nextSequence = seqLZMAPrepare
 					if tmp >= 0xa0 {
@@ -1111,7 +1215,8 @@ func xzDecLZMA2Run(s *xzDecLZMA2, b *xzBuf) xzRet {
 				}
 			} else {
 				if tmp > 0x02 {
-					return xzDataError
+					return lzma2Error(s, xzDataError,
+						"LZMA2 invalid control byte")
 				}
 				s.lzma2.sequence = seqCompressed0
 				s.lzma2.nextSequence = seqCopy
@@ -1134,14 +1239,16 @@ func xzDecLZMA2Run(s *xzDecLZMA2, b *xzBuf) xzRet {
 			s.lzma2.sequence = s.lzma2.nextSequence
 		case seqProperties:
 			if !lzmaProps(s, b.in[b.inPos]) {
-				return xzDataError
+				return lzma2Error(s, xzDataError,
+					"LZMA2 invalid properties")
 			}
 			b.inPos++
 			s.lzma2.sequence = seqLZMAPrepare
 			fallthrough
 		case seqLZMAPrepare:
 			if s.lzma2.compressed < rcInitBytes {
-				return xzDataError
+				return lzma2Error(s, xzDataError,
+					"LZMA2 chunk too small")
 			}
 			if !rcReadInit(&s.rc, b) {
 				return xzOK
@@ -1164,15 +1271,17 @@ func xzDecLZMA2Run(s *xzDecLZMA2, b *xzBuf) xzRet {
 				outMax = s.lzma2.uncompressed
 			}
 			dictLimit(&s.dict, outMax)
-			if !lzma2LZMA(s, b) {
-				return xzDataError
+			if !lzma2LZMA(s, b) || s.lzma.endMarker {
+				return lzma2Error(s, xzDataError,
+					"LZMA data is corrupt")
 			}
 			s.lzma2.uncompressed -= dictFlush(&s.dict, b)
 			switch {
 			case s.lzma2.uncompressed == 0:
 				if s.lzma2.compressed > 0 || s.lzma.len > 0 ||
 					!rcIsFinished(&s.rc) {
-					return xzDataError
+					return lzma2Error(s, xzDataError,
+						"LZMA2 chunk size mismatch")
 				}
 				rcReset(&s.rc)
 				s.lzma2.sequence = seqControl
@@ -1209,21 +1318,64 @@ func xzDecLZMA2Create(dictMax uint32) *xzDecLZMA2 {
  * decoder doesn't support.
  */
 func xzDecLZMA2Reset(s *xzDecLZMA2, props byte) xzRet {
+	dictSize, ok := lzma2DictSize(props)
+	if !ok {
+		// Bigger than 4 GiB
+		return lzma2Error(s, xzOptionsError,
+			"LZMA2 dictionary size too large")
+	}
+	return xzDecLZMA2ResetDict(s, dictSize)
+}
+
+/*
+ * Return the dictionary size given by the LZMA2 properties, or false
+ * if props is invalid.
+ */
+func lzma2DictSize(props byte) (uint32, bool) {
 	if props > 40 {
-		return xzOptionsError // Bigger than 4 GiB
+		return 0, false
 	}
 	if props == 40 {
-		s.dict.size = ^uint32(0)
-	} else {
-		s.dict.size = uint32(2 + props&1)
-		s.dict.size <<= props>>1 + 11
+		return ^uint32(0), true
 	}
-	if s.dict.size > s.dict.sizeMax {
-		return xzMemlimitError
+	dictSize := uint32(2 + props&1)
+	dictSize <<= props>>1 + 11
+	return dictSize, true
+}
+
+/*
+ * Reset the decoder using the given dictionary size, which need not
+ * be one that can be represented by an LZMA2 properties byte. This
+ * is used to decode raw LZMA2 streams. Return xzOK on success or
+ * xzMemlimitError if the dictionary size exceeds the maximum.
+ *
+ * In single-call mode no dictionary buffer is needed, so the maximum
+ * does not apply.
+ */
+func xzDecLZMA2ResetDict(s *xzDecLZMA2, dictSize uint32) xzRet {
+	if dictSize < lzmaDictMin {
+		dictSize = lzmaDictMin
 	}
-	s.dict.end = s.dict.size
-	if len(s.dict.buf) < int(s.dict.size) {
-		s.dict.buf = make([]byte, s.dict.size)
+	s.dict.size = dictSize
+	if s.dict.single {
+		/* dictReset sets buf and end from the output buffer */
+		dictFree(&s.dict)
+	} else {
+		if dictSize > s.dict.sizeMax {
+			return lzma2Error(s, xzMemlimitError,
+				"LZMA2 dictionary size exceeds max")
+		}
+		/*
+		 * The output buffer used by a previous block in single-call
+		 * mode holds decoded data, so it must not be reused.
+		 */
+		if s.dict.output {
+			dictFree(&s.dict)
+		}
+		s.dict.end = s.dict.size
+		if len(s.dict.buf) < int(s.dict.size) {
+			dictAlloc(&s.dict)
+		}
 	}
 	s.lzma.len = 0
 	s.lzma2.sequence = seqControl
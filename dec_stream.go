@@ -101,11 +101,46 @@ type xzDec struct {
 	check hash.Hash
 	/* Embedded stream header struct containing CheckType */
 	*Header
+	/* Description of the last error returned */
+	reason string
 	/*
 	 * True if the next call to xzDecRun is allowed to return
 	 * xzBufError.
 	 */
 	allowBufError bool
+	/*
+	 * Memory usage limit in bytes, or zero for no limit, and the
+	 * memory needed to decode the last Block whose header was
+	 * decoded.
+	 */
+	memLimit    uint64
+	memRequired uint64
+	/*
+	 * Memory used by the caller, such as a Reader or the Index of a
+	 * ReaderAt, which is added to memRequired.
+	 */
+	memCaller uint64
+	/*
+	 * The most uncompressed data the caller allows to be output,
+	 * or -1 for no limit. Blocks declaring a larger uncompressed
+	 * size in their header are rejected.
+	 */
+	outRemain int64
+	/*
+	 * If not nil, called when decMain crosses a Block boundary:
+	 * with start true once a Block Header has been decoded, and
+	 * with start false once the Block's Check field has been
+	 * validated.
+	 */
+	blockHook func(start bool)
+	/* If true, decMain returns xzOK at each Block boundary. */
+	blockStop bool
+	/*
+	 * True in single-call mode, where the output buffer is big
+	 * enough for all the uncompressed data and is used as the
+	 * LZMA2 dictionary of Blocks which have no other filters.
+	 */
+	single bool
 	/* Information stored in Block Header */
 	blockHeader struct {
 		/*
@@ -120,6 +155,11 @@ type xzDec struct {
 		uncompressed vliType
 		/* Size of the Block Header field */
 		size int
+		/* The filter chain, the last filter being LZMA2 */
+		filters []struct {
+			id    xzFilterID
+			props uint32
+		}
 	}
 	/* Information collected when decoding Blocks */
 	block struct {
@@ -175,6 +215,31 @@ type xzDec struct {
 	deltasUsed int
 }
 
+/*
+ * Memory counted against a memory limit. Each value is the size of
+ * the structures it stands for on 64-bit platforms, which the tests
+ * check, rounded up:
+ *
+ * memUsageBase:        xzDec and xzDecLZMA2, the decoder state
+ *                      excluding the LZMA2 dictionary (30096 bytes)
+ * memUsageBCJ:         xzDecBCJ (112 bytes)
+ * memUsageDelta:       xzDecDelta (272 bytes)
+ * memUsageReader:      Reader, or blockReader and its SectionReader,
+ *                      both mostly an input buffer (8376 and 8360
+ *                      bytes)
+ * memUsageIndexStream: xzIndexStream (80 bytes)
+ * memUsageIndexBlock:  xzIndexRecord and xzIndexBlock, held for each
+ *                      Block of a decoded Index (56 bytes)
+ */
+const (
+	memUsageBase        = 30 << 10
+	memUsageBCJ         = 128
+	memUsageDelta       = 320
+	memUsageReader      = 9 << 10
+	memUsageIndexStream = 128
+	memUsageIndexBlock  = 64
+)
+
 /* Sizes of the Check field with different Check IDs */
 var checkSizes = [...]byte{
 	0,
@@ -207,6 +272,15 @@ func fillTemp(s *xzDec, b *xzBuf) bool {
 	return false
 }
 
+/*
+ * Record reason as the cause of the error ret, which is returned. The
+ * reason is reported by Reader.
+ */
+func decError(s *xzDec, ret xzRet, reason string) xzRet {
+	s.reason = reason
+	return ret
+}
+
 /* Decode a variable-length integer (little-endian base-128 encoding) */
 func decVLI(s *xzDec, in []byte, inPos *int) xzRet {
 	var byte byte
@@ -220,14 +294,16 @@ func decVLI(s *xzDec, in []byte, inPos *int) xzRet {
 		if byte&0x80 == 0 {
 			/* Don't allow non-minimal encodings. */
 			if byte == 0 && s.pos != 0 {
-				return xzDataError
+				return decError(s, xzDataError,
+					"invalid variable-length integer")
 			}
 			s.pos = 0
 			return xzStreamEnd
 		}
 		s.pos += 7
 		if s.pos == 7*vliBytesMax {
-			return xzDataError
+			return decError(s, xzDataError,
+				"invalid variable-length integer")
 		}
 	}
 	return xzOK
@@ -250,6 +326,9 @@ func decBlock(s *xzDec, b *xzBuf) xzRet {
 	s.inStart = b.inPos
 	s.outStart = b.outPos
 	ret = s.chain(b)
+	if ret != xzOK && ret != xzStreamEnd {
+		return decError(s, ret, s.lzma2.reason)
+	}
 	s.block.compressed += vliType(b.inPos - s.inStart)
 	s.block.uncompressed += vliType(b.outPos - s.outStart)
 	/*
@@ -258,7 +337,8 @@ func decBlock(s *xzDec, b *xzBuf) xzRet {
 	 */
 	if s.block.compressed > s.blockHeader.compressed ||
 		s.block.uncompressed > s.blockHeader.uncompressed {
-		return xzDataError
+		return decError(s, xzDataError,
+			"block larger than sizes in block header")
 	}
 	switch s.CheckType {
 	case CheckCRC32, CheckCRC64, CheckSHA256:
@@ -267,11 +347,13 @@ func decBlock(s *xzDec, b *xzBuf) xzRet {
 	if ret == xzStreamEnd {
 		if s.blockHeader.compressed != vliUnknown &&
 			s.blockHeader.compressed != s.block.compressed {
-			return xzDataError
+			return decError(s, xzDataError,
+				"block compressed size mismatch")
 		}
 		if s.blockHeader.uncompressed != vliUnknown &&
 			s.blockHeader.uncompressed != s.block.uncompressed {
-			return xzDataError
+			return decError(s, xzDataError,
+				"block uncompressed size mismatch")
 		}
 		s.block.hash.unpadded +=
 			vliType(s.blockHeader.size) + s.block.compressed
@@ -318,7 +400,8 @@ func decIndex(s *xzDec, b *xzBuf) xzRet {
 			 * there were Blocks in the Stream.
 			 */
 			if s.index.count != s.block.count {
-				return xzDataError
+				return decError(s, xzDataError,
+					"index record count mismatch")
 			}
 			s.index.sequence = seqIndexUnpadded
 		case seqIndexUnpadded:
@@ -353,7 +436,7 @@ func crcValidate(s *xzDec, b *xzBuf) xzRet {
 			return xzOK
 		}
 		if sum[s.pos] != b.in[b.inPos] {
-			return xzDataError
+			return decError(s, xzDataError, "index CRC32 mismatch")
 		}
 		b.inPos++
 		s.pos++
@@ -383,7 +466,8 @@ func checkValidate(s *xzDec, b *xzBuf) xzRet {
 			return xzOK
 		}
 		if sum[s.pos] != b.in[b.inPos] {
-			return xzDataError
+			return decError(s, xzDataError,
+				s.CheckType.String()+" check mismatch")
 		}
 		b.inPos++
 		s.pos++
@@ -418,14 +502,14 @@ var xzCRC64Table = crc64.MakeTable(crc64.ECMA)
 /* Decode the Stream Header field (the first 12 bytes of the .xz Stream). */
 func decStreamHeader(s *xzDec) xzRet {
 	if string(s.temp.buf[:len(headerMagic)]) != headerMagic {
-		return xzFormatError
+		return decError(s, xzFormatError, "stream header magic mismatch")
 	}
 	if crc32.ChecksumIEEE(s.temp.buf[len(headerMagic):len(headerMagic)+2]) !=
 		getLE32(s.temp.buf[len(headerMagic)+2:]) {
-		return xzDataError
+		return decError(s, xzDataError, "stream header CRC32 mismatch")
 	}
 	if s.temp.buf[len(headerMagic)] != 0 {
-		return xzOptionsError
+		return decError(s, xzOptionsError, "unsupported stream flags")
 	}
 	/*
 	 * Of integrity checks, we support none (Check ID = 0),
@@ -435,8 +519,16 @@ func decStreamHeader(s *xzDec) xzRet {
 	 */
 	s.CheckType = CheckID(s.temp.buf[len(headerMagic)+1])
 	if s.CheckType > checkMax {
-		return xzOptionsError
+		return decError(s, xzOptionsError, "unsupported stream flags")
 	}
+	return decCheckInit(s)
+}
+
+/*
+ * Prepare s.check for calculating the Check fields of s.CheckType.
+ * Returns xzUnsupportedCheck if the check type is not supported.
+ */
+func decCheckInit(s *xzDec) xzRet {
 	switch s.CheckType {
 	case CheckNone:
 		// CheckNone: no action needed
@@ -462,7 +554,7 @@ func decStreamHeader(s *xzDec) xzRet {
 		}
 		s.check = s.checkSHA256
 	default:
-		return xzUnsupportedCheck
+		return decError(s, xzUnsupportedCheck, "unsupported check type")
 	}
 	return xzOK
 }
@@ -470,10 +562,10 @@ func decStreamHeader(s *xzDec) xzRet {
 /* Decode the Stream Footer field (the last 12 bytes of the .xz Stream) */
 func decStreamFooter(s *xzDec) xzRet {
 	if string(s.temp.buf[10:10+len(footerMagic)]) != footerMagic {
-		return xzDataError
+		return decError(s, xzDataError, "stream footer magic mismatch")
 	}
 	if crc32.ChecksumIEEE(s.temp.buf[4:10]) != getLE32(s.temp.buf) {
-		return xzDataError
+		return decError(s, xzDataError, "stream footer CRC32 mismatch")
 	}
 	/*
 	 * Validate Backward Size. Note that we never added the size of the
@@ -481,10 +573,12 @@ func decStreamFooter(s *xzDec) xzRet {
 	 * instead of s->index.size / 4 - 1.
 	 */
 	if s.index.size>>2 != vliType(getLE32(s.temp.buf[4:])) {
-		return xzDataError
+		return decError(s, xzDataError,
+			"stream footer backward size mismatch")
 	}
 	if s.temp.buf[8] != 0 || CheckID(s.temp.buf[9]) != s.CheckType {
-		return xzDataError
+		return decError(s, xzDataError,
+			"stream footer flags differ from stream header")
 	}
 	/*
 	 * Use xzStreamEnd instead of xzOK to be more convenient
@@ -503,28 +597,31 @@ func decBlockHeader(s *xzDec) xzRet {
 	crc := getLE32(s.temp.buf[len(s.temp.buf)-4:])
 	s.temp.buf = s.temp.buf[:len(s.temp.buf)-4]
 	if crc32.ChecksumIEEE(s.temp.buf) != crc {
-		return xzDataError
+		return decError(s, xzDataError, "block header CRC32 mismatch")
 	}
 	s.temp.pos = 2
 	/*
 	 * Catch unsupported Block Flags.
 	 */
 	if s.temp.buf[1]&0x3C != 0 {
-		return xzOptionsError
+		return decError(s, xzOptionsError, "unsupported block flags")
 	}
 	/* Compressed Size */
 	if s.temp.buf[1]&0x40 != 0 {
 		if decVLI(s, s.temp.buf, &s.temp.pos) != xzStreamEnd {
-			return xzDataError
+			return decError(s, xzDataError,
+				"block header compressed size invalid")
 		}
 		if s.vli >= 1<<63-8 {
 			// the whole block must stay smaller than 2^63 bytes
 			// the block header cannot be smaller than 8 bytes
-			return xzDataError
+			return decError(s, xzDataError,
+				"block header compressed size invalid")
 		}
 		if s.vli == 0 {
 			// compressed size must be non-zero
-			return xzDataError
+			return decError(s, xzDataError,
+				"block header compressed size invalid")
 		}
 		s.blockHeader.compressed = s.vli
 	} else {
@@ -533,9 +630,14 @@ func decBlockHeader(s *xzDec) xzRet {
 	/* Uncompressed Size */
 	if s.temp.buf[1]&0x80 != 0 {
 		if decVLI(s, s.temp.buf, &s.temp.pos) != xzStreamEnd {
-			return xzDataError
+			return decError(s, xzDataError,
+				"block header uncompressed size invalid")
 		}
 		s.blockHeader.uncompressed = s.vli
+		if s.outRemain >= 0 && s.vli > vliType(s.outRemain) {
+			return decError(s, xzOutputLimitError,
+				"block uncompressed size exceeds limit")
+		}
 	} else {
 		s.blockHeader.uncompressed = vliUnknown
 	}
@@ -550,18 +652,21 @@ func decBlockHeader(s *xzDec) xzRet {
 	for i := 0; i < filterTotal-1; i++ {
 		/* Valid Filter Flags always take at least two bytes. */
 		if len(s.temp.buf)-s.temp.pos < 2 {
-			return xzDataError
+			return decError(s, xzDataError,
+				"block header filter flags truncated")
 		}
 		s.temp.pos += 2
 		switch id := xzFilterID(s.temp.buf[s.temp.pos-2]); id {
 		case idDelta:
 			// delta filter
 			if s.temp.buf[s.temp.pos-1] != 0x01 {
-				return xzOptionsError
+				return decError(s, xzOptionsError,
+					"unsupported Delta filter properties")
 			}
 			/* Filter Properties contains distance - 1 */
 			if len(s.temp.buf)-s.temp.pos < 1 {
-				return xzDataError
+				return decError(s, xzDataError,
+					"block header filter flags truncated")
 			}
 			props := uint32(s.temp.buf[s.temp.pos])
 			s.temp.pos++
@@ -570,7 +675,7 @@ func decBlockHeader(s *xzDec) xzRet {
 				props uint32
 			}{id: id, props: props}
 		case idBCJX86, idBCJPowerPC, idBCJIA64,
-			idBCJARM, idBCJARMThumb, idBCJSPARC:
+			idBCJARM, idBCJARMThumb, idBCJSPARC, idBCJARM64:
 			// bcj filter
 			var props uint32
 			switch s.temp.buf[s.temp.pos-1] {
@@ -578,40 +683,45 @@ func decBlockHeader(s *xzDec) xzRet {
 				props = 0
 			case 0x04:
 				if len(s.temp.buf)-s.temp.pos < 4 {
-					return xzDataError
+					return decError(s, xzDataError,
+						"block header filter flags truncated")
 				}
 				props = getLE32(s.temp.buf[s.temp.pos:])
 				s.temp.pos += 4
 			default:
-				return xzOptionsError
+				return decError(s, xzOptionsError,
+					"unsupported BCJ filter properties")
 			}
 			filterList[i] = struct {
 				id    xzFilterID
 				props uint32
 			}{id: id, props: props}
 		default:
-			return xzOptionsError
+			return decError(s, xzOptionsError, "unsupported filter")
 		}
 	}
 	/*
 	 * decode the last filter which must be LZMA2
 	 */
 	if len(s.temp.buf)-s.temp.pos < 2 {
-		return xzDataError
+		return decError(s, xzDataError,
+			"block header filter flags truncated")
 	}
 	/* Filter ID = LZMA2 */
 	if xzFilterID(s.temp.buf[s.temp.pos]) != idLZMA2 {
-		return xzOptionsError
+		return decError(s, xzOptionsError, "last filter is not LZMA2")
 	}
 	s.temp.pos++
 	/* Size of Properties = 1-byte Filter Properties */
 	if s.temp.buf[s.temp.pos] != 0x01 {
-		return xzOptionsError
+		return decError(s, xzOptionsError,
+			"unsupported LZMA2 filter properties")
 	}
 	s.temp.pos++
 	/* Filter Properties contains LZMA2 dictionary size. */
 	if len(s.temp.buf)-s.temp.pos < 1 {
-		return xzDataError
+		return decError(s, xzDataError,
+			"block header filter flags truncated")
 	}
 	props := uint32(s.temp.buf[s.temp.pos])
 	s.temp.pos++
@@ -619,15 +729,39 @@ func decBlockHeader(s *xzDec) xzRet {
 		id    xzFilterID
 		props uint32
 	}{id: idLZMA2, props: props}
+	s.blockHeader.filters = filterList
 	/*
 	 * Process the filter list and create s.chain, going from last
 	 * filter (LZMA2) to first filter
 	 *
-	 * First, LZMA2.
+	 * First, LZMA2. The BCJ and Delta filters modify the data in
+	 * the output buffer, so it can be the dictionary only if there
+	 * are no other filters.
 	 */
+	s.lzma2.dict.single = s.single && filterTotal == 1
+	/*
+	 * Check the memory needed to decode the Block. The dictionary
+	 * is not counted in single-call mode as it is the output buffer.
+	 */
+	s.memRequired = memUsageBase + s.memCaller
+	dictSize, ok := lzma2DictSize(byte(filterList[filterTotal-1].props))
+	if ok && !s.lzma2.dict.single {
+		s.memRequired += uint64(dictSize)
+	}
+	for i := 0; i < filterTotal-1; i++ {
+		if filterList[i].id == idDelta {
+			s.memRequired += memUsageDelta
+		} else {
+			s.memRequired += memUsageBCJ
+		}
+	}
+	if s.memLimit != 0 && s.memRequired > s.memLimit {
+		return decError(s, xzMemlimitError,
+			"decoder memory usage exceeds limit")
+	}
 	ret = xzDecLZMA2Reset(s.lzma2, byte(filterList[filterTotal-1].props))
 	if ret != xzOK {
-		return ret
+		return decError(s, ret, s.lzma2.reason)
 	}
 	s.chain = func(b *xzBuf) xzRet {
 		return xzDecLZMA2Run(s.lzma2, b)
@@ -649,14 +783,14 @@ func decBlockHeader(s *xzDec) xzRet {
 			s.deltasUsed++
 			ret = xzDecDeltaReset(delta, int(filterList[i].props)+1)
 			if ret != xzOK {
-				return ret
+				return decError(s, ret, "invalid Delta filter distance")
 			}
 			chain := s.chain
 			s.chain = func(b *xzBuf) xzRet {
 				return xzDecDeltaRun(delta, b, chain)
 			}
 		case idBCJX86, idBCJPowerPC, idBCJIA64,
-			idBCJARM, idBCJARMThumb, idBCJSPARC:
+			idBCJARM, idBCJARMThumb, idBCJSPARC, idBCJARM64:
 			// bcj filter
 			var bcj *xzDecBCJ
 			if s.bcjsUsed < len(s.bcjs) {
@@ -668,7 +802,8 @@ func decBlockHeader(s *xzDec) xzRet {
 			s.bcjsUsed++
 			ret = xzDecBCJReset(bcj, id, int(filterList[i].props))
 			if ret != xzOK {
-				return ret
+				return decError(s, ret,
+					"unsupported BCJ filter start offset")
 			}
 			chain := s.chain
 			s.chain = func(b *xzBuf) xzRet {
@@ -676,10 +811,26 @@ func decBlockHeader(s *xzDec) xzRet {
 			}
 		}
 	}
+	/*
+	 * The BCJ and Delta filters work in place in b.out, so the
+	 * LZMA2 decoder must copy its output there rather than output
+	 * directly from the dictionary.
+	 */
+	if filterTotal > 1 {
+		chain := s.chain
+		s.chain = func(b *xzBuf) xzRet {
+			direct := b.direct
+			b.direct = false
+			ret := chain(b)
+			b.direct = direct
+			return ret
+		}
+	}
 	/* The rest must be Header Padding. */
 	for s.temp.pos < len(s.temp.buf) {
 		if s.temp.buf[s.temp.pos] != 0x00 {
-			return xzOptionsError
+			return decError(s, xzOptionsError,
+				"non-zero block header padding")
 		}
 		s.temp.pos++
 	}
@@ -752,6 +903,12 @@ func decMain(s *xzDec, b *xzBuf) xzRet {
 				return ret
 			}
 			s.sequence = seqBlockUncompress
+			if s.blockHook != nil {
+				s.blockHook(true)
+			}
+			if s.blockStop {
+				return xzOK
+			}
 			fallthrough
 		case seqBlockUncompress:
 			ret = decBlock(s, b)
@@ -773,7 +930,8 @@ func decMain(s *xzDec, b *xzBuf) xzRet {
 					return xzOK
 				}
 				if b.in[b.inPos] != 0 {
-					return xzDataError
+					return decError(s, xzDataError,
+						"non-zero block padding")
 				}
 				b.inPos++
 				s.block.compressed++
@@ -793,6 +951,12 @@ func decMain(s *xzDec, b *xzBuf) xzRet {
 				}
 			}
 			s.sequence = seqBlockStart
+			if s.blockHook != nil {
+				s.blockHook(false)
+			}
+			if s.blockStop {
+				return xzOK
+			}
 		case seqIndex:
 			ret = decIndex(s, b)
 			if ret != xzStreamEnd {
@@ -807,7 +971,8 @@ func decMain(s *xzDec, b *xzBuf) xzRet {
 					return xzOK
 				}
 				if b.in[b.inPos] != 0 {
-					return xzDataError
+					return decError(s, xzDataError,
+						"non-zero index padding")
 				}
 				b.inPos++
 			}
@@ -816,7 +981,8 @@ func decMain(s *xzDec, b *xzBuf) xzRet {
 			/* Compare the hashes to validate the Index field. */
 			if !bytes.Equal(
 				s.block.hash.sha256.Sum(nil), s.index.hash.sha256.Sum(nil)) {
-				return xzDataError
+				return decError(s, xzDataError,
+					"index records do not match blocks")
 			}
 			s.sequence = seqIndexCRC32
 			fallthrough
@@ -862,7 +1028,7 @@ func xzDecRun(s *xzDec, b *xzBuf) xzRet {
 	ret := decMain(s, b)
 	if ret == xzOK && inStart == b.inPos && outStart == b.outPos {
 		if s.allowBufError {
-			ret = xzBufError
+			ret = decError(s, xzBufError, "unexpected end of input")
 		}
 		s.allowBufError = true
 	} else {
@@ -894,6 +1060,7 @@ func xzDecInit(dictMax uint32, header *Header) *xzDec {
 	s.block.hash.sha256 = sha256.New()
 	s.index.hash.sha256 = sha256.New()
 	s.lzma2 = xzDecLZMA2Create(dictMax)
+	s.outRemain = -1
 	xzDecReset(s)
 	return s
 }
@@ -908,6 +1075,8 @@ func xzDecInit(dictMax uint32, header *Header) *xzDec {
 func xzDecReset(s *xzDec) {
 	s.sequence = seqStreamHeader
 	s.allowBufError = false
+	s.reason = ""
+	s.memRequired = 0
 	s.pos = 0
 	s.crc32.Reset()
 	s.check = nil
@@ -930,3 +1099,24 @@ func xzDecReset(s *xzDec) {
 	s.bcjsUsed = 0
 	s.deltasUsed = 0
 }
+
+/**
+ * xzDecResetBlock - Reset the decoder to decode a single Block
+ * @s:          Decoder state allocated using xzDecInit
+ * @checkType:  Check type of the Stream containing the Block
+ *
+ * After this call the input to xzDecRun must begin with a Block
+ * Header. Once the Block has been decoded, s.block.count is one and
+ * xzDecRun returns xzOK waiting for the next Block Header. This is
+ * used for random access, where the Stream Header and Index are
+ * decoded separately.
+ *
+ * Returns xzUnsupportedCheck if the Check field can only be skipped
+ * over, otherwise xzOK.
+ */
+func xzDecResetBlock(s *xzDec, checkType CheckID) xzRet {
+	xzDecReset(s)
+	s.CheckType = checkType
+	s.sequence = seqBlockStart
+	return decCheckInit(s)
+}
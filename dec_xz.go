@@ -12,6 +12,8 @@
 
 package xz
 
+import "fmt"
+
 /* from linux/include/linux/xz.h **************************************/
 
 /**
@@ -33,6 +35,9 @@ package xz
  *                          itself specifies something that we don't support.
  * @xzDataError:            Compressed data is corrupt.
  * @xzBufError:             Cannot make any progress.
+ * @xzOutputLimitError:     A Block Header declares an uncompressed size
+ *                          larger than the caller allows (see outRemain
+ *                          in xzDec).
  *
  * xzBufError is returned when two consecutive calls to XZ code cannot
  * consume any input and cannot produce any new output.  This happens
@@ -52,6 +57,7 @@ const (
 	xzOptionsError
 	xzDataError
 	xzBufError
+	xzOutputLimitError
 )
 
 /**
@@ -62,15 +68,21 @@ const (
  * @out:        Output buffer.
  * @outPos:     Current position in the output buffer. This must not exceed
  *              output buffer size.
+ * @direct:     If true and outPos is zero, the LZMA2 decoder may replace
+ *              out with a slice of its dictionary holding the decoded
+ *              data instead of copying the data to out. The slice is
+ *              only valid until the next call to the XZ code.
  *
  * Only the contents of the output buffer from out[outPos] onward, and
- * the variables inPos and outPos are modified by the XZ code.
+ * the variables inPos and outPos are modified by the XZ code, unless
+ * direct is set.
  */
 type xzBuf struct {
 	in     []byte
 	inPos  int
 	out    []byte
 	outPos int
+	direct bool
 }
 
 /* All XZ filter IDs */
@@ -84,6 +96,7 @@ const (
 	idBCJARM      xzFilterID = 0x07
 	idBCJARMThumb xzFilterID = 0x08
 	idBCJSPARC    xzFilterID = 0x09
+	idBCJARM64    xzFilterID = 0x0A
 	idLZMA2       xzFilterID = 0x21
 )
 
@@ -122,3 +135,99 @@ const (
 type Header struct {
 	CheckType CheckID // type of the stream's data integrity check
 }
+
+// FilterID identifies a filter in a block's filter chain.
+type FilterID uint64
+
+func (id FilterID) String() string {
+	switch id {
+	case FilterDelta:
+		return "Delta"
+	case FilterX86:
+		return "x86"
+	case FilterPowerPC:
+		return "PowerPC"
+	case FilterIA64:
+		return "IA-64"
+	case FilterARM:
+		return "ARM"
+	case FilterARMThumb:
+		return "ARM-Thumb"
+	case FilterSPARC:
+		return "SPARC"
+	case FilterARM64:
+		return "ARM64"
+	case FilterLZMA2:
+		return "LZMA2"
+	default:
+		return "Unknown"
+	}
+}
+
+// The IDs of the filters which may appear in a filter chain. The BCJ
+// filters FilterX86 to FilterARM64 convert branch instructions of the
+// named architecture; FilterLZMA2 must be the last filter.
+const (
+	FilterDelta    FilterID = FilterID(idDelta)
+	FilterX86      FilterID = FilterID(idBCJX86)
+	FilterPowerPC  FilterID = FilterID(idBCJPowerPC)
+	FilterIA64     FilterID = FilterID(idBCJIA64)
+	FilterARM      FilterID = FilterID(idBCJARM)
+	FilterARMThumb FilterID = FilterID(idBCJARMThumb)
+	FilterSPARC    FilterID = FilterID(idBCJSPARC)
+	FilterARM64    FilterID = FilterID(idBCJARM64)
+	FilterLZMA2    FilterID = FilterID(idLZMA2)
+)
+
+// A Filter is an entry in a block's filter chain. Props holds the
+// filter's properties as stored in the block header: the distance
+// minus one for FilterDelta, the start offset for the BCJ filters
+// and the dictionary size byte for FilterLZMA2.
+type Filter struct {
+	ID    FilterID
+	Props uint32
+}
+
+// String returns the filter's name followed by its options, in the
+// form used by XZ Utils, for example "LZMA2 dict=8MiB", "Delta
+// dist=4" or "x86 start=1024". A BCJ filter's start offset is shown
+// only if it is not zero.
+func (f Filter) String() string {
+	switch f.ID {
+	case FilterDelta:
+		return fmt.Sprintf("%v dist=%d", f.ID, f.Props+1)
+	case FilterLZMA2:
+		if dictSize, ok := lzma2DictSize(byte(f.Props)); ok {
+			return fmt.Sprintf("%v dict=%s", f.ID, dictSizeStr(dictSize))
+		}
+	default:
+		if f.Props != 0 {
+			return fmt.Sprintf("%v start=%d", f.ID, f.Props)
+		}
+	}
+	return f.ID.String()
+}
+
+// dictSizeStr formats a dictionary size in the largest binary unit
+// dividing it exactly.
+func dictSizeStr(size uint32) string {
+	switch {
+	case size%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", size>>20)
+	case size%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", size>>10)
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+// A BlockHeader describes a block of an XZ stream, as given by the
+// block's header.
+type BlockHeader struct {
+	Offset             int64    // offset of the block in the input
+	UncompressedOffset int64    // offset of the block's data
+	HeaderSize         int      // size of the block header
+	CompressedSize     int64    // declared compressed size, or -1
+	UncompressedSize   int64    // declared uncompressed size, or -1
+	DictSize           uint32   // the LZMA2 dictionary size
+	Filters            []Filter // the filter chain, ending with LZMA2
+}
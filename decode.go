@@ -0,0 +1,131 @@
+/*
+ * Package xz Go single-call Decode API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"bytes"
+	"math"
+)
+
+// ratioMax is the largest plausible ratio of uncompressed to
+// compressed size. It is above the ratio LZMA2 reaches on a run of
+// zeros, so only a forged Index claims more. It bounds the memory
+// allocated up front from the sizes in an Index.
+const ratioMax = 1 << 13
+
+// Decode decompresses src, which holds a complete XZ file, and
+// appends the uncompressed data to dst, returning the updated
+// slice. As with Reader, src may be a concatenation of XZ streams
+// with stream padding. If the uncompressed data would be larger than
+// limit bytes Decode returns ErrOutputLimit before decompressing
+// anything. A limit of zero or less means no limit.
+//
+// Decode first reads the Index of each stream to find the size of
+// the uncompressed data. If limit is positive and the size is
+// plausible for the size of src, dst is grown at most once and src is
+// decoded directly into it, which also serves as the LZMA2
+// dictionary, so no dictionary is allocated. Blocks using BCJ or
+// Delta filters are the exception: these need a separate dictionary,
+// of up to DefaultDictMax bytes. Otherwise the size in the Index is
+// not trusted for allocation: dst is grown as the data is decoded,
+// with every block using a separate dictionary.
+//
+// On error the original dst is returned.
+func Decode(dst, src []byte, limit int) ([]byte, error) {
+	return DecodeOptions(dst, src, &ReaderOptions{MaxOutput: int64(limit)})
+}
+
+// DecodeOptions is like Decode but takes its settings from opts, with
+// MaxOutput in place of limit. Only DictMax, MemLimit and MaxOutput
+// are used. A nil opts is equivalent to a zero ReaderOptions.
+//
+// MemLimit limits the memory used by the Index of each stream while it
+// is read, and by the decoder of each block, counting its dictionary
+// and filters but not dst. If more is needed DecodeOptions returns
+// ErrMemlimit, as an *Error whose MemRequired field gives the memory
+// needed if a block needs more.
+func DecodeOptions(dst, src []byte, opts *ReaderOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &ReaderOptions{}
+	}
+	x, ret, err := decFileIndex(bytes.NewReader(src), int64(len(src)),
+		opts.MemLimit)
+	if err != nil {
+		return dst, err
+	}
+	if ret != xzOK {
+		return dst, retError(ret)
+	}
+	limit := opts.MaxOutput
+	size := x.uncompressed
+	if limit > 0 && size > limit ||
+		size > int64(math.MaxInt-len(dst)) {
+		return dst, ErrOutputLimit
+	}
+	n := len(dst)
+	end := n + int(size)
+	// a dictionary position is a uint32
+	single := limit > 0 && size <= math.MaxUint32 &&
+		size/ratioMax <= int64(len(src))
+	alloc := end
+	if !single && alloc-n > len(src) {
+		alloc = n + len(src)
+	}
+	out := dst
+	if cap(out) < alloc {
+		out = make([]byte, n, alloc)
+		copy(out, dst)
+	}
+	b := &xzBuf{in: src, out: out[:alloc], outPos: n}
+	s := xzDecInit(opts.dictMax(), &Header{})
+	s.memLimit = opts.MemLimit
+	s.single = single
+	streams := 0
+	padding := -1
+	for b.inPos < len(b.in) || padding == -1 {
+		if padding >= 0 {
+			// skip stream padding
+			for b.inPos < len(b.in) && b.in[b.inPos] == 0 {
+				b.inPos++
+				padding++
+			}
+			if padding%4 != 0 {
+				ret := decError(s, xzDataError,
+					"stream padding size not a multiple of four")
+				return dst, newError(s, ret, int64(b.inPos), streams,
+					int64(b.outPos-n), true)
+			}
+			if b.inPos < len(b.in) {
+				xzDecReset(s)
+				padding = -1
+			}
+			continue
+		}
+		if b.outPos == len(b.out) && len(b.out) < end {
+			// grow the output buffer, at most to the size in the Index
+			b.out = append(b.out, 0)
+			b.out = b.out[:cap(b.out)]
+			if len(b.out) > end {
+				b.out = b.out[:end]
+			}
+		}
+		switch ret := xzDecRun(s, b); ret {
+		case xzOK:
+			// no action needed
+		case xzStreamEnd:
+			streams++
+			padding = 0
+		default:
+			return dst, newError(s, ret, int64(b.inPos), streams,
+				int64(b.outPos-n), false)
+		}
+	}
+	return b.out[:b.outPos], nil
+}
@@ -0,0 +1,261 @@
+/*
+ * Package xz Decode tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"runtime"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+func TestDecode(t *testing.T) {
+	fileList := badFiles
+	fileList = append(fileList, goodFiles...)
+	fileList = append(fileList, unsupportedFiles...)
+	fileList = append(fileList, otherFiles...)
+	for _, f := range fileList {
+		src, err := readTestFile(f.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := xz.Decode(nil, src, 0)
+		if f.err != nil {
+			// the Index may show the file to be bad before
+			// decoding so the error can differ from Reader's
+			if err == nil {
+				t.Fatalf("%s: wanted error: %v, got: nil\n", f.file, f.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: wanted error: nil, got: %v\n", f.file, err)
+		}
+		md5sum := fmt.Sprintf("%x", md5.Sum(data))
+		if f.md5sum != md5sum {
+			t.Fatalf(
+				"%s: wanted md5: %v, got: %v\n", f.file, f.md5sum, md5sum)
+		}
+	}
+}
+
+func TestDecodeAppend(t *testing.T) {
+	src, err := readTestFile("words-blocks.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := decodeTestFile(t, "words-blocks.xz")
+	prefix := []byte("prefix")
+	for _, dst := range [][]byte{
+		prefix,
+		append(make([]byte, 0, len(prefix)+len(want)), prefix...),
+	} {
+		data, err := xz.Decode(dst, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[:len(prefix)], prefix) ||
+			!bytes.Equal(data[len(prefix):], want) {
+			t.Fatal("decoded data differs")
+		}
+	}
+}
+
+func TestDecodeLimit(t *testing.T) {
+	src, err := readTestFile("zeros-100mb.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := []byte("prefix")
+	data, err := xz.Decode(dst, src, 100000000-1)
+	if err != xz.ErrOutputLimit || !bytes.Equal(data, dst) {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrOutputLimit, err)
+	}
+	data, err = xz.Decode(nil, src, 100000000)
+	if err != nil || len(data) != 100000000 {
+		t.Fatalf("Decode returned: (%d,%v), expected: (100000000,nil)\n",
+			len(data), err)
+	}
+}
+
+func TestDecodeDictSize(t *testing.T) {
+	// words.xz with its block header changed to use a 1 GiB
+	// dictionary, which Decode does not need to allocate when given
+	// a limit
+	src, err := readTestFile("words.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src = append([]byte(nil), src...)
+	src[16] = 36
+	binary.LittleEndian.PutUint32(src[20:], crc32.ChecksumIEEE(src[12:20]))
+	z, err := xz.NewReader(bytes.NewReader(src), 0)
+	if err == nil {
+		_, err = z.WriteTo(new(bytes.Buffer))
+	}
+	if !errors.Is(err, xz.ErrMemlimit) {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
+	}
+	want := decodeTestFile(t, "words.xz")
+	if _, err = xz.Decode(nil, src, 0); !errors.Is(err, xz.ErrMemlimit) {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
+	}
+	data, err := xz.Decode(nil, src, len(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatal("decoded data differs")
+	}
+}
+
+// getVLI returns the variable-length integer at b[*pos:], advancing
+// *pos past it.
+func getVLI(b []byte, pos *int) uint64 {
+	var x uint64
+	for i := uint(0); ; i += 7 {
+		c := b[*pos]
+		*pos++
+		x |= uint64(c&0x7f) << i
+		if c&0x80 == 0 {
+			return x
+		}
+	}
+}
+
+// setIndexSize returns a copy of the single stream XZ file src with
+// the uncompressed size of block i in its Index changed to size. The
+// CRC32 fields are updated so only the sizes are inconsistent.
+func setIndexSize(t *testing.T, src []byte, i int, size uint64) []byte {
+	footer := src[len(src)-12:]
+	start := len(src) - 12 - int(binary.LittleEndian.Uint32(footer[4:])+1)*4
+	pos := start + 1
+	count := int(getVLI(src, &pos))
+	if i >= count {
+		t.Fatalf("block %d of %d blocks", i, count)
+	}
+	file := append([]byte(nil), src[:start]...)
+	file = append(file, 0x00)
+	file = putVLI(file, uint64(count))
+	for j := 0; j < count; j++ {
+		unpadded := getVLI(src, &pos)
+		uncompressed := getVLI(src, &pos)
+		if j == i {
+			uncompressed = size
+		}
+		file = putVLI(file, unpadded)
+		file = putVLI(file, uncompressed)
+	}
+	for (len(file)-start)&3 != 0 {
+		file = append(file, 0x00)
+	}
+	file = putCRC32(file, start)
+	footer = append([]byte(nil), footer...)
+	binary.LittleEndian.PutUint32(footer[4:], uint32((len(file)-start)/4-1))
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(footer[4:10]))
+	return append(file, footer...)
+}
+
+func TestDecodeMixedFilters(t *testing.T) {
+	// blocks and streams which have only an LZMA2 filter are decoded
+	// directly into the output, the others using a dictionary
+	var src, want []byte
+	for _, file := range []string{
+		"words.xz",
+		"good-1-x86-lzma2.xz",
+		"words-mixed-filters.xz",
+		"good-1-delta-lzma2.tiff.xz",
+		"good-1-lzma2-1.xz",
+		"good-1-arm64-lzma2.xz",
+	} {
+		b, err := readTestFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src = append(src, b...)
+		want = append(want, decodeTestFile(t, file)...)
+		data, err := xz.Decode(nil, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Fatalf("%s: decoded data differs", file)
+		}
+	}
+}
+
+func TestDecodeForgedIndex(t *testing.T) {
+	// an Index claiming a huge size must not cause a huge allocation
+	src, err := readTestFile("good-1-lzma2-1.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []uint64{1 << 30, 1 << 40, 1 << 60} {
+		forged := setIndexSize(t, src, 0, size)
+		if _, err = xz.Decode(nil, forged, 0); !errors.Is(err, xz.ErrData) {
+			t.Fatalf("size %d: wanted error: %v, got: %v\n",
+				size, xz.ErrData, err)
+		}
+	}
+}
+
+func TestDecodeNoLimit(t *testing.T) {
+	// without a limit a plausible but false size in the Index is not
+	// allocated up front
+	src, err := readTestFile("good-1-lzma2-1.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 1 << 21
+	forged := setIndexSize(t, src, 0, size)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = xz.Decode(nil, forged, 0)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, xz.ErrData) {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrData, err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n >= size/2 {
+		t.Fatalf("allocated %d bytes", n)
+	}
+}
+
+func TestDecodeMemLimit(t *testing.T) {
+	// words.xz has a 64 MiB dictionary, which is only allocated
+	// without a limit on the output
+	src, err := readTestFile("words.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := decodeTestFile(t, "words.xz")
+	for _, test := range []struct {
+		opts xz.ReaderOptions
+		err  error
+	}{
+		{xz.ReaderOptions{MemLimit: 64}, xz.ErrMemlimit},
+		{xz.ReaderOptions{MemLimit: 1 << 20}, xz.ErrMemlimit},
+		{xz.ReaderOptions{MemLimit: 1 << 20, MaxOutput: int64(len(want))}, nil},
+		{xz.ReaderOptions{MemLimit: 65 << 20}, nil},
+	} {
+		data, err := xz.DecodeOptions(nil, src, &test.opts)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%+v: wanted error: %v, got: %v\n", test.opts, test.err, err)
+		}
+		if err == nil && !bytes.Equal(data, want) {
+			t.Fatalf("%+v: decoded data differs", test.opts)
+		}
+	}
+}
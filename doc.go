@@ -1,9 +1,11 @@
-// Package xz implements XZ decompression natively in Go.
+// Package xz implements XZ compression and decompression natively in
+// Go.
 //
 // Usage
 //
 // For ease of use, this package is designed to have a similar API to
-// compress/gzip. See the examples for further details.
+// compress/gzip. See the examples for further details. An XZ file
+// held in memory can also be decompressed in a single call to Decode.
 //
 // Implementation
 //
@@ -11,8 +13,24 @@
 // (http://tukaani.org/xz/embedded.html) with enhancements made so as
 // to implement all mandatory and optional parts of the XZ file format
 // specification v1.0.4. It supports all filters and block check
-// types, supports multiple streams, and performs index verification
-// using SHA-256 as recommended by the specification.
+// types, as well as the ARM64 filter added in v1.1.0 of the
+// specification, supports multiple streams, and performs index
+// verification using SHA-256 as recommended by the specification.
+//
+// The compressor is a translation of the LZMA2 encoder of XZ Utils
+// (http://tukaani.org/xz/) using its fast mode. It produces XZ files
+// with a single LZMA2 filter which can be decompressed by XZ Utils and
+// by this package. The Preset values 0 to 9 and PresetExtreme select
+// the dictionary sizes of the XZ Utils presets. ParallelWriter
+// compresses fixed-size blocks concurrently, producing files which
+// ParallelReader can decompress concurrently. Executable code may be
+// prepared for compression with BCJWriter.
+//
+// Legacy .lzma files, which hold a single LZMA stream without the XZ
+// container, can be decompressed with LZMAReader, as can raw LZMA1
+// and LZMA2 streams embedded in other formats. Lzip files can be
+// decompressed with LzipReader. RegisterZipDecompressors allows
+// archive/zip to read ZIP members compressed using LZMA or XZ.
 //
 // Speed
 //
@@ -0,0 +1,297 @@
+/*
+ * Branch/Call/Jump (BCJ) filter encoders
+ *
+ * Authors: Lasse Collin <lasse.collin@tukaani.org>
+ *          Igor Pavlov <http://7-zip.org/>
+ *
+ * Translation to Go: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+/*
+ * The encoders are the filters of dec_bcj.go run in the opposite
+ * direction, as done by liblzma/simple/*.c when is_encoder is true:
+ * relative addresses are converted to absolute ones by adding the
+ * position instead of subtracting it. Each function converts as much
+ * of buf as it can and returns the number of bytes converted; the
+ * rest must be passed again together with the data that follows.
+ */
+
+type xzEncBCJ struct {
+	/* Type of the BCJ filter being used */
+	typ xzFilterID
+	/*
+	 * Absolute position relative to the beginning of the uncompressed
+	 * data (in a single .xz Block).
+	 */
+	pos int
+	/* x86 filter state */
+	x86PrevMask uint32
+}
+
+func bcjX86Encode(s *xzEncBCJ, buf []byte) int {
+	var maskToAllowedStatus = []bool{
+		true, true, true, false, true, false, false, false,
+	}
+	var maskToBitNum = []byte{0, 1, 2, 2, 3, 3, 3, 3}
+	var i int
+	var prevPos int = -1
+	var prevMask uint32 = s.x86PrevMask
+	var src uint32
+	var dest uint32
+	var j uint32
+	var b byte
+	if len(buf) <= 4 {
+		return 0
+	}
+	for i = 0; i < len(buf)-4; i++ {
+		if buf[i]&0xfe != 0xe8 {
+			continue
+		}
+		prevPos = i - prevPos
+		if prevPos > 3 {
+			prevMask = 0
+		} else {
+			prevMask = (prevMask << (uint(prevPos) - 1)) & 7
+			if prevMask != 0 {
+				b = buf[i+4-int(maskToBitNum[prevMask])]
+				if !maskToAllowedStatus[prevMask] || bcjX86TestMSByte(b) {
+					prevPos = i
+					prevMask = prevMask<<1 | 1
+					continue
+				}
+			}
+		}
+		prevPos = i
+		if bcjX86TestMSByte(buf[i+4]) {
+			src = getLE32(buf[i+1:])
+			for {
+				dest = src + uint32(s.pos+i+5)
+				if prevMask == 0 {
+					break
+				}
+				j = uint32(maskToBitNum[prevMask]) * 8
+				b = byte(dest >> (24 - j))
+				if !bcjX86TestMSByte(b) {
+					break
+				}
+				src = dest ^ (1<<(32-j) - 1)
+			}
+			dest &= 0x01FFFFFF
+			dest |= 0 - dest&0x01000000
+			putLE32(dest, buf[i+1:])
+			i += 4
+		} else {
+			prevMask = prevMask<<1 | 1
+		}
+	}
+	prevPos = i - prevPos
+	if prevPos > 3 {
+		s.x86PrevMask = 0
+	} else {
+		s.x86PrevMask = prevMask << (uint(prevPos) - 1)
+	}
+	return i
+}
+
+func bcjPowerPCEncode(s *xzEncBCJ, buf []byte) int {
+	var i int
+	var instr uint32
+	for i = 0; i+4 <= len(buf); i += 4 {
+		instr = getBE32(buf[i:])
+		if instr&0xFC000003 == 0x48000001 {
+			instr &= 0x03FFFFFC
+			instr += uint32(s.pos + i)
+			instr &= 0x03FFFFFC
+			instr |= 0x48000001
+			putBE32(instr, buf[i:])
+		}
+	}
+	return i
+}
+
+func bcjIA64Encode(s *xzEncBCJ, buf []byte) int {
+	var branchTable = bcjIA64BranchTable[:]
+	/* Loop counters */
+	var i int
+	var j int
+	/* Instruction slot (0, 1, or 2) in the 128-bit instruction word */
+	var slot uint32
+	/* Bitwise offset of the instruction indicated by slot */
+	var bitPos uint32
+	/* bit_pos split into byte and bit parts */
+	var bytePos uint32
+	var bitRes uint32
+	/* Address part of an instruction */
+	var addr uint32
+	/* Mask used to detect which instructions to convert */
+	var mask uint32
+	/* 41-bit instruction stored somewhere in the lowest 48 bits */
+	var instr uint64
+	/* Instruction normalized with bit_res for easier manipulation */
+	var norm uint64
+	for i = 0; i+16 <= len(buf); i += 16 {
+		mask = uint32(branchTable[buf[i]&0x1f])
+		for slot, bitPos = 0, 5; slot < 3; slot, bitPos = slot+1, bitPos+41 {
+			if (mask>>slot)&1 == 0 {
+				continue
+			}
+			bytePos = bitPos >> 3
+			bitRes = bitPos & 7
+			instr = 0
+			for j = 0; j < 6; j++ {
+				instr |= uint64(buf[i+j+int(bytePos)]) << (8 * uint(j))
+			}
+			norm = instr >> bitRes
+			if (norm>>37)&0x0f == 0x05 && (norm>>9)&0x07 == 0 {
+				addr = uint32((norm >> 13) & 0x0fffff)
+				addr |= (uint32(norm>>36) & 1) << 20
+				addr <<= 4
+				addr += uint32(s.pos + i)
+				addr >>= 4
+				norm &= ^(uint64(0x8fffff) << 13)
+				norm |= uint64(addr&0x0fffff) << 13
+				norm |= uint64(addr&0x100000) << (36 - 20)
+				instr &= 1<<bitRes - 1
+				instr |= norm << bitRes
+				for j = 0; j < 6; j++ {
+					buf[i+j+int(bytePos)] = byte(instr >> (8 * uint(j)))
+				}
+			}
+		}
+	}
+	return i
+}
+
+func bcjARMEncode(s *xzEncBCJ, buf []byte) int {
+	var i int
+	var addr uint32
+	for i = 0; i+4 <= len(buf); i += 4 {
+		if buf[i+3] == 0xeb {
+			addr = uint32(buf[i]) | uint32(buf[i+1])<<8 |
+				uint32(buf[i+2])<<16
+			addr <<= 2
+			addr += uint32(s.pos + i + 8)
+			addr >>= 2
+			buf[i] = byte(addr)
+			buf[i+1] = byte(addr >> 8)
+			buf[i+2] = byte(addr >> 16)
+		}
+	}
+	return i
+}
+
+func bcjARMThumbEncode(s *xzEncBCJ, buf []byte) int {
+	var i int
+	var addr uint32
+	for i = 0; i+4 <= len(buf); i += 2 {
+		if buf[i+1]&0xf8 == 0xf0 && buf[i+3]&0xf8 == 0xf8 {
+			addr = uint32(buf[i+1]&0x07)<<19 |
+				uint32(buf[i])<<11 |
+				uint32(buf[i+3]&0x07)<<8 |
+				uint32(buf[i+2])
+			addr <<= 1
+			addr += uint32(s.pos + i + 4)
+			addr >>= 1
+			buf[i+1] = byte(0xf0 | (addr>>19)&0x07)
+			buf[i] = byte(addr >> 11)
+			buf[i+3] = byte(0xf8 | (addr>>8)&0x07)
+			buf[i+2] = byte(addr)
+			i += 2
+		}
+	}
+	return i
+}
+
+func bcjSPARCEncode(s *xzEncBCJ, buf []byte) int {
+	var i int
+	var instr uint32
+	for i = 0; i+4 <= len(buf); i += 4 {
+		instr = getBE32(buf[i:])
+		if instr>>22 == 0x100 || instr>>22 == 0x1ff {
+			instr <<= 2
+			instr += uint32(s.pos + i)
+			instr >>= 2
+			instr = (0x40000000 - instr&0x400000) |
+				0x40000000 | (instr & 0x3FFFFF)
+			putBE32(instr, buf[i:])
+		}
+	}
+	return i
+}
+
+func bcjARM64Encode(s *xzEncBCJ, buf []byte) int {
+	var i int
+	var instr uint32
+	var addr uint32
+	for i = 0; i+4 <= len(buf); i += 4 {
+		instr = getLE32(buf[i:])
+		if instr>>26 == 0x25 {
+			/* BL instruction */
+			addr = instr + uint32(s.pos+i)>>2
+			instr = 0x94000000 | addr&0x03FFFFFF
+			putLE32(instr, buf[i:])
+		} else if instr&0x9F000000 == 0x90000000 {
+			/* ADRP instruction */
+			addr = (instr>>29)&3 | (instr>>3)&0x1FFFFC
+			/* Only convert values in the range +/-512 MiB. */
+			if (addr+0x020000)&0x1C0000 != 0 {
+				continue
+			}
+			addr += uint32(s.pos+i) >> 12
+			instr &= 0x9000001F
+			instr |= (addr & 3) << 29
+			instr |= (addr & 0x03FFFC) << 3
+			instr |= (0 - addr&0x020000) & 0xE00000
+			putLE32(instr, buf[i:])
+		}
+	}
+	return i
+}
+
+/*
+ * Apply the selected BCJ encoder to buf, returning the number of
+ * bytes converted. s.pos is updated to match.
+ */
+func xzEncBCJRun(s *xzEncBCJ, buf []byte) int {
+	var filtered int
+	switch s.typ {
+	case idBCJX86:
+		filtered = bcjX86Encode(s, buf)
+	case idBCJPowerPC:
+		filtered = bcjPowerPCEncode(s, buf)
+	case idBCJIA64:
+		filtered = bcjIA64Encode(s, buf)
+	case idBCJARM:
+		filtered = bcjARMEncode(s, buf)
+	case idBCJARMThumb:
+		filtered = bcjARMThumbEncode(s, buf)
+	case idBCJSPARC:
+		filtered = bcjSPARCEncode(s, buf)
+	case idBCJARM64:
+		filtered = bcjARM64Encode(s, buf)
+	default:
+		/* Never reached */
+	}
+	s.pos += filtered
+	return filtered
+}
+
+/*
+ * Select the BCJ filter and start offset, which are checked in the
+ * same way as by xzDecBCJReset. Returns xzOK if the given Filter ID
+ * and offset is supported. Otherwise xzOptionsError is returned.
+ */
+func xzEncBCJReset(s *xzEncBCJ, id xzFilterID, offset int) xzRet {
+	if ret := bcjCheck(id, offset); ret != xzOK {
+		return ret
+	}
+	s.typ = id
+	s.pos = offset
+	s.x86PrevMask = 0
+	return xzOK
+}
@@ -0,0 +1,411 @@
+/*
+ * LZ encoder window and hash chain match finder
+ *
+ * Authors: Lasse Collin <lasse.collin@tukaani.org>
+ *          Igor Pavlov <http://7-zip.org/>
+ *
+ * Translation to Go: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import "hash/crc32"
+
+/* from liblzma/lz/lz_encoder.h ***************************************/
+
+/* Maximum length of a match: 273 bytes */
+const matchLenMax = matchLenMin + lenLowSymbols + lenMidSymbols +
+	lenHighSymbols - 1
+
+/*
+ * Number of bytes that must be available after the current position
+ * before the encoder may run without being told that there is no
+ * more input coming.
+ */
+const lzKeepAfter = matchLenMax + 1
+
+/* Sizes of the hash tables used to find two and three byte matches */
+const (
+	hash2Size = 1 << 10
+	hash3Size = 1 << 16
+)
+
+/* A match as reported by the match finder */
+type lzMatch struct {
+	len  uint32
+	dist uint32 // distance minus one
+}
+
+/*
+ * lzEncoder holds the sliding window and the match finder state.
+ *
+ * These are always true:
+ *    readPos - readAhead <= readPos <= readLimit <= writePos <= len(buf)
+ */
+type lzEncoder struct {
+	/*
+	 * The history buffer followed by input which has not yet been
+	 * encoded. Only buf[:writePos] holds valid data.
+	 */
+	buf []byte
+	/* Position in buf of the next byte to run through the match finder */
+	readPos int
+	/*
+	 * Number of bytes which have been run through the match finder
+	 * but have not yet been encoded.
+	 */
+	readAhead int
+	/* The match finder may not be run at or beyond readLimit */
+	readLimit int
+	/* Position in buf where new input is copied to */
+	writePos int
+	/*
+	 * Number of bytes before readPos that must be kept when the
+	 * window is moved.
+	 */
+	keepBefore int
+	/* True once the caller has indicated there is no more input */
+	finishing bool
+	/*
+	 * Offset added to readPos to get the position values that are
+	 * stored in the hash tables. Position values smaller than
+	 * cyclicSize are never stored, so a value of zero in the hash
+	 * tables means "no match".
+	 */
+	offset uint32
+	/* Matches longer than niceLen are not searched for */
+	niceLen uint32
+	/* Maximum number of hash chain entries to follow */
+	depth uint32
+	/* Current position in son */
+	cyclicPos uint32
+	/* Size of son; this is the dictionary size plus one */
+	cyclicSize uint32
+	/* Hash tables for two, three and four byte hashes */
+	hash2    []uint32
+	hash3    []uint32
+	hash4    []uint32
+	hashMask uint32
+	/* Hash chain: the previous position with the same four byte hash */
+	son []uint32
+	/* Matches found by the last call to lzFind */
+	matches [matchLenMax]lzMatch
+}
+
+/* from liblzma/lz/lz_encoder.c ***************************************/
+
+/*
+ * Allocate memory for a match finder using a dictionary of dictSize
+ * bytes. lzReset must be used before the match finder is used.
+ */
+func lzCreate(dictSize uint32, niceLen uint32, depth uint32) *lzEncoder {
+	mf := new(lzEncoder)
+	/*
+	 * Reserve space so that the window doesn't need to be moved
+	 * too often. A minimum of 64 KiB is kept in addition to the
+	 * dictionary so that an LZMA2 chunk that turns out to be
+	 * incompressible can be copied from the window.
+	 */
+	mf.keepBefore = int(dictSize) + 1<<16
+	reserve := int(dictSize/2) + 1<<19
+	mf.buf = make([]byte, mf.keepBefore+reserve+lzKeepAfter)
+	mf.cyclicSize = dictSize + 1
+	mf.son = make([]uint32, mf.cyclicSize)
+	/*
+	 * Size the four byte hash table according to the dictionary
+	 * size. Its size is always a power of two.
+	 */
+	hs := dictSize - 1
+	hs |= hs >> 1
+	hs |= hs >> 2
+	hs |= hs >> 4
+	hs |= hs >> 8
+	hs |= hs >> 16
+	hs >>= 1
+	hs |= 0xffff
+	if hs > 1<<24 {
+		hs >>= 1
+	}
+	mf.hashMask = hs
+	mf.hash2 = make([]uint32, hash2Size)
+	mf.hash3 = make([]uint32, hash3Size)
+	mf.hash4 = make([]uint32, hs+1)
+	if niceLen > matchLenMax {
+		niceLen = matchLenMax
+	}
+	mf.niceLen = niceLen
+	if depth == 0 {
+		depth = 4 + niceLen/4
+	}
+	mf.depth = depth
+	return mf
+}
+
+/* Reset the match finder so that it is ready to encode a new stream. */
+func lzReset(mf *lzEncoder) {
+	mf.readPos = 0
+	mf.readAhead = 0
+	mf.readLimit = 0
+	mf.writePos = 0
+	mf.finishing = false
+	mf.offset = mf.cyclicSize
+	mf.cyclicPos = 0
+	for _, h := range [][]uint32{mf.hash2, mf.hash3, mf.hash4} {
+		for i := range h {
+			h[i] = 0
+		}
+	}
+	/*
+	 * There is no need to clear son as entries are always written
+	 * before they can be read.
+	 */
+}
+
+/*
+ * Move the data in the window towards the beginning of buf, keeping
+ * at least keepBefore bytes before the current encoding position.
+ */
+func lzMoveWindow(mf *lzEncoder) {
+	moveOffset := mf.readPos - mf.readAhead - mf.keepBefore
+	if moveOffset <= 0 {
+		return
+	}
+	copy(mf.buf, mf.buf[moveOffset:mf.writePos])
+	mf.offset += uint32(moveOffset)
+	mf.readPos -= moveOffset
+	mf.readLimit -= moveOffset
+	mf.writePos -= moveOffset
+}
+
+/* Update readLimit after more input has arrived or finishing was set. */
+func lzSetLimit(mf *lzEncoder) {
+	if mf.finishing {
+		mf.readLimit = mf.writePos
+	} else {
+		mf.readLimit = mf.writePos - lzKeepAfter
+		if mf.readLimit < mf.readPos {
+			mf.readLimit = mf.readPos
+		}
+	}
+}
+
+/*
+ * Copy as much of in as will fit into the window, moving the window
+ * first if it is nearly full. Returns the number of bytes copied.
+ */
+func lzFill(mf *lzEncoder, in []byte) int {
+	if mf.writePos == len(mf.buf) {
+		lzMoveWindow(mf)
+	}
+	n := copy(mf.buf[mf.writePos:], in)
+	mf.writePos += n
+	lzSetLimit(mf)
+	return n
+}
+
+/* Number of bytes available at and after readPos */
+func lzAvail(mf *lzEncoder) int {
+	return mf.writePos - mf.readPos
+}
+
+/*
+ * Return the number of bytes which are equal in buf[a:] and buf[b:]
+ * given that the first n bytes are already known to be equal. At most
+ * limit bytes are compared.
+ */
+func lzMemcmplen(buf []byte, a int, b int, n uint32, limit uint32) uint32 {
+	for n < limit && buf[a+int(n)] == buf[b+int(n)] {
+		n++
+	}
+	return n
+}
+
+/*
+ * When the position values are about to overflow, subtract a constant
+ * from all of them. Values that would become too small are set to
+ * zero which means "no match".
+ */
+func lzNormalize(mf *lzEncoder) {
+	subValue := ^uint32(0) - mf.cyclicSize
+	for _, h := range [][]uint32{mf.hash2, mf.hash3, mf.hash4, mf.son} {
+		for i := range h {
+			if h[i] <= subValue {
+				h[i] = 0
+			} else {
+				h[i] -= subValue
+			}
+		}
+	}
+	mf.offset -= subValue
+}
+
+/* Advance the match finder by one byte. */
+func lzMovePos(mf *lzEncoder) {
+	mf.cyclicPos++
+	if mf.cyclicPos == mf.cyclicSize {
+		mf.cyclicPos = 0
+	}
+	mf.readPos++
+	if uint32(mf.readPos)+mf.offset == ^uint32(0) {
+		lzNormalize(mf)
+	}
+}
+
+/* from liblzma/lz/lz_encoder_mf.c ************************************/
+
+/*
+ * Calculate the hash values of the four bytes at buf[cur:]. The two
+ * and three byte hashes have the useful property that, given equal
+ * first bytes, equal hashes imply equal second (and third) bytes.
+ */
+func lzHash4(mf *lzEncoder, cur int) (h2, h3, h4 uint32) {
+	b := mf.buf[cur : cur+4]
+	temp := crc32.IEEETable[b[0]] ^ uint32(b[1])
+	h2 = temp & (hash2Size - 1)
+	h3 = (temp ^ uint32(b[2])<<8) & (hash3Size - 1)
+	h4 = (temp ^ uint32(b[2])<<8 ^ crc32.IEEETable[b[3]]<<5) & mf.hashMask
+	return
+}
+
+/*
+ * Follow the hash chain starting at curMatch and append matches
+ * longer than lenBest to mf.matches[count:]. Returns the new number
+ * of matches.
+ */
+func lzHCFind(mf *lzEncoder, lenLimit uint32, pos uint32, cur int,
+	curMatch uint32, count int, lenBest uint32) int {
+	depth := mf.depth
+	mf.son[mf.cyclicPos] = curMatch
+	for {
+		delta := pos - curMatch
+		if depth == 0 || delta >= mf.cyclicSize {
+			return count
+		}
+		depth--
+		pb := cur - int(delta)
+		if delta > mf.cyclicPos {
+			curMatch = mf.son[mf.cyclicPos-delta+mf.cyclicSize]
+		} else {
+			curMatch = mf.son[mf.cyclicPos-delta]
+		}
+		if mf.buf[pb+int(lenBest)] == mf.buf[cur+int(lenBest)] &&
+			mf.buf[pb] == mf.buf[cur] {
+			l := lzMemcmplen(mf.buf, pb, cur, 1, lenLimit)
+			if lenBest < l {
+				lenBest = l
+				mf.matches[count] = lzMatch{len: l, dist: delta - 1}
+				count++
+				if l == lenLimit {
+					return count
+				}
+			}
+		}
+	}
+}
+
+/*
+ * Find matches at readPos, storing them in mf.matches in order of
+ * increasing length, and advance the match finder by one byte.
+ * Returns the number of matches found.
+ */
+func lzFindHC4(mf *lzEncoder) int {
+	lenLimit := uint32(lzAvail(mf))
+	if lenLimit < 4 {
+		/* Too little input left to hash; this only happens at the end. */
+		mf.readPos++
+		return 0
+	}
+	if lenLimit > mf.niceLen {
+		lenLimit = mf.niceLen
+	}
+	cur := mf.readPos
+	pos := uint32(mf.readPos) + mf.offset
+	h2, h3, h4 := lzHash4(mf, cur)
+	delta2 := pos - mf.hash2[h2]
+	delta3 := pos - mf.hash3[h3]
+	curMatch := mf.hash4[h4]
+	mf.hash2[h2] = pos
+	mf.hash3[h3] = pos
+	mf.hash4[h4] = pos
+	lenBest := uint32(1)
+	count := 0
+	if delta2 < mf.cyclicSize && mf.buf[cur-int(delta2)] == mf.buf[cur] {
+		lenBest = 2
+		mf.matches[0] = lzMatch{len: 2, dist: delta2 - 1}
+		count = 1
+	}
+	if delta2 != delta3 && delta3 < mf.cyclicSize &&
+		mf.buf[cur-int(delta3)] == mf.buf[cur] {
+		lenBest = 3
+		mf.matches[count].dist = delta3 - 1
+		count++
+		delta2 = delta3
+	}
+	if count != 0 {
+		lenBest = lzMemcmplen(mf.buf, cur-int(delta2), cur, lenBest, lenLimit)
+		mf.matches[count-1].len = lenBest
+		if lenBest == lenLimit {
+			mf.son[mf.cyclicPos] = curMatch
+			lzMovePos(mf)
+			return count
+		}
+	}
+	if lenBest < 3 {
+		lenBest = 3
+	}
+	count = lzHCFind(mf, lenLimit, pos, cur, curMatch, count, lenBest)
+	lzMovePos(mf)
+	return count
+}
+
+/* Run amount bytes through the match finder without looking for matches. */
+func lzSkipHC4(mf *lzEncoder, amount int) {
+	for ; amount > 0; amount-- {
+		if lzAvail(mf) < 4 {
+			mf.readPos++
+			continue
+		}
+		pos := uint32(mf.readPos) + mf.offset
+		h2, h3, h4 := lzHash4(mf, mf.readPos)
+		curMatch := mf.hash4[h4]
+		mf.hash2[h2] = pos
+		mf.hash3[h3] = pos
+		mf.hash4[h4] = pos
+		mf.son[mf.cyclicPos] = curMatch
+		lzMovePos(mf)
+	}
+}
+
+/*
+ * Find matches at the next byte. Returns the number of matches found
+ * and the length of the longest one. If the longest match reached
+ * niceLen, its length is extended as far as possible.
+ */
+func lzFind(mf *lzEncoder) (count int, lenBest uint32) {
+	count = lzFindHC4(mf)
+	if count > 0 {
+		lenBest = mf.matches[count-1].len
+		if lenBest == mf.niceLen {
+			limit := uint32(lzAvail(mf) + 1)
+			if limit > matchLenMax {
+				limit = matchLenMax
+			}
+			p1 := mf.readPos - 1
+			p2 := p1 - int(mf.matches[count-1].dist) - 1
+			lenBest = lzMemcmplen(mf.buf, p1, p2, lenBest, limit)
+		}
+	}
+	mf.readAhead++
+	return
+}
+
+/* Skip amount bytes, adding them to the hash chains. */
+func lzSkip(mf *lzEncoder, amount int) {
+	if amount > 0 {
+		lzSkipHC4(mf, amount)
+		mf.readAhead += amount
+	}
+}
@@ -0,0 +1,790 @@
+/*
+ * LZMA2 encoder
+ *
+ * Authors: Lasse Collin <lasse.collin@tukaani.org>
+ *          Igor Pavlov <http://7-zip.org/>
+ *
+ * Translation to Go: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import "math/bits"
+
+/* from liblzma/lzma/lzma2_encoder.h **********************************/
+
+const (
+	/* Maximum compressed size of an LZMA2 chunk */
+	lzma2ChunkMax = 1 << 16
+	/* Maximum uncompressed size of an LZMA2 chunk */
+	lzma2UncompressedMax = 1 << 21
+)
+
+/*
+ * Maximum number of bytes a single LZMA symbol can add to the output
+ * of the range encoder, rounded up generously. Encoding of a chunk
+ * stops once the compressed size gets within this distance of
+ * lzma2ChunkMax.
+ */
+const lzmaOutRequired = 32
+
+/* Special value of back returned by lzmaOptimumFast meaning "literal" */
+const lzmaBackLiteral = ^uint32(0)
+
+/* Number of remembered repeat distances */
+const reps = 4
+
+/*
+ * LZMA2 encoder options
+ *
+ * The encoder implements only the fast mode using the HC4 match
+ * finder, so unlike liblzma's lzma_options_lzma there is no choice of
+ * mode or match finder.
+ */
+type lzma2Options struct {
+	/* Dictionary size in bytes */
+	dictSize uint32
+	/* Number of literal context bits */
+	lc uint32
+	/* Number of literal position bits */
+	lp uint32
+	/* Number of position bits */
+	pb uint32
+	/* Matches of at least this length are taken immediately */
+	niceLen uint32
+	/* Maximum match finder search depth (zero selects a default) */
+	depth uint32
+}
+
+/*
+ * The options used by Writer: an 8 MiB dictionary, as used by XZ
+ * Utils by default, combined with the fast encoding mode.
+ */
+var lzma2DefaultOptions = lzma2Options{
+	dictSize: 1 << 23,
+	lc:       3,
+	lp:       0,
+	pb:       2,
+	niceLen:  273,
+	depth:    48,
+}
+
+/* from liblzma/lzma/lzma_encoder_presets.c ***************************/
+
+/* Bit set in a preset number to select the extreme variant */
+const lzmaPresetExtreme = 1 << 31
+
+/* Dictionary sizes of the presets 0-9, as powers of two */
+var lzmaPresetDictPow2 = [10]uint8{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+
+/*
+ * Match finder search depths of the presets 0-9. Those of the presets
+ * 0-3 are as in liblzma, where they use the fast mode too, though
+ * preset 0 uses HC3 rather than HC4. The presets 4-9 use the normal
+ * mode and BT4 match finder in liblzma, which this encoder lacks, so
+ * their depths and nice match lengths are not liblzma's. They instead
+ * search deeper as the level rises, which in practice keeps their
+ * output no larger than that of the presets below them.
+ */
+var lzmaPresetDepth = [10]uint32{4, 8, 24, 48, 64, 96, 128, 192, 256, 256}
+
+/*
+ * Return the options of the given XZ Utils preset, which is a level
+ * from 0 to 9, optionally ORed with lzmaPresetExtreme. The dictionary
+ * size and literal and position bits are those of liblzma, as are the
+ * nice match length and depth of the presets 0-3. The match finder
+ * and mode of liblzma are ignored, see the doc comment of Preset. The
+ * extreme variant, which uses the normal mode in liblzma, instead
+ * doubles the depth. Returns false if the preset is invalid.
+ */
+func lzma2PresetOptions(preset uint32) (lzma2Options, bool) {
+	var opts lzma2Options
+	level := preset &^ lzmaPresetExtreme
+	if level > 9 {
+		return opts, false
+	}
+	opts.dictSize = 1 << lzmaPresetDictPow2[level]
+	opts.lc = 3
+	opts.lp = 0
+	opts.pb = 2
+	opts.niceLen = 273
+	if level <= 1 {
+		opts.niceLen = 128
+	}
+	opts.depth = lzmaPresetDepth[level]
+	if preset&lzmaPresetExtreme != 0 {
+		opts.niceLen = 273
+		opts.depth *= 2
+	}
+	return opts, true
+}
+
+/* from liblzma/rangecoder/range_encoder.h ****************************/
+
+/* Range encoder */
+type rcEnc struct {
+	low       uint64
+	rnge      uint32
+	cache     byte
+	cacheSize int
+	/* Encoded output */
+	out []byte
+}
+
+/* Reset the range encoder, discarding any output. */
+func rcEncReset(rc *rcEnc) {
+	rc.low = 0
+	rc.rnge = ^uint32(0)
+	rc.cache = 0
+	rc.cacheSize = 1
+	rc.out = rc.out[:0]
+}
+
+func rcShiftLow(rc *rcEnc) {
+	if uint32(rc.low) < 0xff000000 || rc.low>>32 != 0 {
+		carry := byte(rc.low >> 32)
+		b := rc.cache
+		for {
+			rc.out = append(rc.out, b+carry)
+			b = 0xff
+			rc.cacheSize--
+			if rc.cacheSize == 0 {
+				break
+			}
+		}
+		rc.cache = byte(rc.low >> 24)
+	}
+	rc.cacheSize++
+	rc.low = (rc.low & 0x00ffffff) << rcShiftBits
+}
+
+/* Encode one bit. */
+func rcEncBit(rc *rcEnc, prob *uint16, bit uint32) {
+	bound := (rc.rnge >> rcBitModelTotalBits) * uint32(*prob)
+	if bit == 0 {
+		rc.rnge = bound
+		*prob += (rcBitModelTotal - *prob) >> rcMoveBits
+	} else {
+		rc.low += uint64(bound)
+		rc.rnge -= bound
+		*prob -= *prob >> rcMoveBits
+	}
+	if rc.rnge < rcTopValue {
+		rc.rnge <<= rcShiftBits
+		rcShiftLow(rc)
+	}
+}
+
+/*
+ * Encode the lowest nbits of symbol as a bittree starting from the
+ * most significant bit. probs[1:] is used in the same way as by
+ * rcBittree.
+ */
+func rcEncBittree(rc *rcEnc, probs []uint16, nbits uint32, symbol uint32) {
+	var m uint32 = 1
+	for nbits > 0 {
+		nbits--
+		bit := (symbol >> nbits) & 1
+		rcEncBit(rc, &probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+/*
+ * Encode the lowest nbits of symbol as a bittree starting from the
+ * least significant bit. probs is used in the same way as by
+ * rcBittreeReverse.
+ */
+func rcEncBittreeReverse(rc *rcEnc, probs []uint16, nbits uint32, symbol uint32) {
+	var m uint32 = 1
+	for ; nbits > 0; nbits-- {
+		bit := symbol & 1
+		symbol >>= 1
+		rcEncBit(rc, &probs[m-1], bit)
+		m = m<<1 | bit
+	}
+}
+
+/* Encode the lowest nbits of value as direct bits. */
+func rcEncDirect(rc *rcEnc, value uint32, nbits uint32) {
+	for nbits > 0 {
+		nbits--
+		rc.rnge >>= 1
+		if (value>>nbits)&1 != 0 {
+			rc.low += uint64(rc.rnge)
+		}
+		if rc.rnge < rcTopValue {
+			rc.rnge <<= rcShiftBits
+			rcShiftLow(rc)
+		}
+	}
+}
+
+/* Flush the range encoder. */
+func rcEncFlush(rc *rcEnc) {
+	for i := 0; i < rcInitBytes; i++ {
+		rcShiftLow(rc)
+	}
+}
+
+/*
+ * Return the number of bytes the output would have if the range
+ * encoder were flushed now.
+ */
+func rcPending(rc *rcEnc) int {
+	return len(rc.out) + rc.cacheSize + rcInitBytes - 1
+}
+
+/* from liblzma/lzma/lzma_encoder.c ***********************************/
+
+/* Probabilities for a length encoder, laid out as in lzmaLenDec. */
+type lzmaLenEnc lzmaLenDec
+
+type lzmaEnc struct {
+	/* Distances of latest four matches */
+	reps [reps]uint32
+	/* Types of the most recently seen LZMA symbols */
+	state lzmaState
+	/*
+	 * LZMA properties or related bit masks, as in lzmaDec.
+	 */
+	lc             uint32
+	literalPosMask uint32
+	posMask        uint32
+	/*
+	 * Uncompressed position since the last dictionary reset. It is
+	 * 64 bits, as a Block may hold more than 4 GiB and a position of
+	 * zero means no byte has been coded, so it must not wrap around.
+	 */
+	pos uint64
+	/*
+	 * Matches found for the byte which has been run through the
+	 * match finder but not yet encoded (see lzmaOptimumFast).
+	 */
+	matchCount      int
+	longestMatchLen uint32
+	/* Probabilities, as in lzmaDec */
+	isMatch     [states][posStatesMax]uint16
+	isRep       [states]uint16
+	isRep0      [states]uint16
+	isRep1      [states]uint16
+	isRep2      [states]uint16
+	isRep0Long  [states][posStatesMax]uint16
+	distSlot    [distStates][distSlots]uint16
+	distSpecial [fullDistances - distModelEnd]uint16
+	distAlign   [alignSize]uint16
+	matchLenEnc lzmaLenEnc
+	repLenEnc   lzmaLenEnc
+	literal     [literalCodersMax][literalCoderSize]uint16
+}
+
+/*
+ * Reset the LZMA encoder state. This mirrors lzmaReset so that the
+ * encoder and decoder stay in step.
+ */
+func lzmaEncReset(s *lzmaEnc) {
+	s.state = stateLitLit
+	s.reps = [reps]uint32{}
+	v := uint16(rcBitModelTotal / 2)
+	for _, l := range []*lzmaLenEnc{&s.matchLenEnc, &s.repLenEnc} {
+		l.choice = v
+		l.choice2 = v
+		for i := range l.low {
+			for j := range l.low[i] {
+				l.low[i][j] = v
+				l.mid[i][j] = v
+			}
+		}
+		for i := range l.high {
+			l.high[i] = v
+		}
+	}
+	for _, m := range [][]uint16{
+		s.isRep[:], s.isRep0[:], s.isRep1[:],
+		s.isRep2[:], s.distSpecial[:], s.distAlign[:],
+	} {
+		for j := range m {
+			m[j] = v
+		}
+	}
+	for i := range s.isMatch {
+		for j := range s.isMatch[i] {
+			s.isMatch[i][j] = v
+			s.isRep0Long[i][j] = v
+		}
+	}
+	for i := range s.distSlot {
+		for j := range s.distSlot[i] {
+			s.distSlot[i][j] = v
+		}
+	}
+	for i := range s.literal {
+		for j := range s.literal[i] {
+			s.literal[i][j] = v
+		}
+	}
+}
+
+/* Calculate the bit masks from lc/lp/pb. Return the properties byte. */
+func lzmaEncProps(s *lzmaEnc, lc, lp, pb uint32) byte {
+	s.lc = lc
+	s.literalPosMask = 1<<lp - 1
+	s.posMask = 1<<pb - 1
+	return byte((pb*5+lp)*9 + lc)
+}
+
+/* Return the distance slot of a distance (minus one). */
+func lzmaGetDistSlot(dist uint32) uint32 {
+	if dist < distModelStart {
+		return dist
+	}
+	n := uint32(bits.Len32(dist)) - 1
+	return n<<1 | (dist>>(n-1))&1
+}
+
+/* Encode a literal (one 8-bit byte) at the current position. */
+func lzmaEncLiteral(s *xzEncLZMA2, cur int) {
+	buf := s.mf.buf
+	var prevByte uint32
+	if s.lzma.pos > 0 {
+		prevByte = uint32(buf[cur-1])
+	}
+	low := prevByte >> (8 - s.lzma.lc)
+	high := (uint32(s.lzma.pos) & s.lzma.literalPosMask) << s.lzma.lc
+	probs := s.lzma.literal[low+high][:]
+	symbol := uint32(buf[cur]) | 0x100
+	if lzmaStateIsLiteral(s.lzma.state) {
+		for symbol < 0x10000 {
+			rcEncBit(&s.rc, &probs[symbol>>8], (symbol>>7)&1)
+			symbol <<= 1
+		}
+	} else {
+		matchByte := uint32(buf[cur-int(s.lzma.reps[0])-1])
+		offset := uint32(0x100)
+		for symbol < 0x10000 {
+			matchByte <<= 1
+			matchBit := matchByte & offset
+			rcEncBit(&s.rc, &probs[offset+matchBit+symbol>>8], (symbol>>7)&1)
+			symbol <<= 1
+			offset &= ^(matchByte ^ symbol)
+		}
+	}
+	lzmaStateLiteral(&s.lzma.state)
+}
+
+/* Encode the length of a match. */
+func lzmaEncLen(s *xzEncLZMA2, l *lzmaLenEnc, len uint32, posState uint32) {
+	len -= matchLenMin
+	if len < lenLowSymbols {
+		rcEncBit(&s.rc, &l.choice, 0)
+		rcEncBittree(&s.rc, l.low[posState][:], lenLowBits, len)
+		return
+	}
+	rcEncBit(&s.rc, &l.choice, 1)
+	len -= lenLowSymbols
+	if len < lenMidSymbols {
+		rcEncBit(&s.rc, &l.choice2, 0)
+		rcEncBittree(&s.rc, l.mid[posState][:], lenMidBits, len)
+		return
+	}
+	rcEncBit(&s.rc, &l.choice2, 1)
+	rcEncBittree(&s.rc, l.high[:], lenHighBits, len-lenMidSymbols)
+}
+
+/* Encode a match with the given distance (minus one) and length. */
+func lzmaEncMatch(s *xzEncLZMA2, dist uint32, len uint32, posState uint32) {
+	lzmaStateMatch(&s.lzma.state)
+	lzmaEncLen(s, &s.lzma.matchLenEnc, len, posState)
+	distSlot := lzmaGetDistSlot(dist)
+	rcEncBittree(&s.rc, s.lzma.distSlot[lzmaGetDistState(len)][:],
+		distSlotBits, distSlot)
+	if distSlot >= distModelStart {
+		footerBits := distSlot>>1 - 1
+		base := (2 | distSlot&1) << footerBits
+		reduced := dist - base
+		if distSlot < distModelEnd {
+			rcEncBittreeReverse(&s.rc, s.lzma.distSpecial[base-distSlot:],
+				footerBits, reduced)
+		} else {
+			rcEncDirect(&s.rc, reduced>>alignBits, footerBits-alignBits)
+			rcEncBittreeReverse(&s.rc, s.lzma.distAlign[1:],
+				alignBits, reduced&(alignSize-1))
+		}
+	}
+	s.lzma.reps[3] = s.lzma.reps[2]
+	s.lzma.reps[2] = s.lzma.reps[1]
+	s.lzma.reps[1] = s.lzma.reps[0]
+	s.lzma.reps[0] = dist
+}
+
+/* Encode a repeated match using rep distance number rep. */
+func lzmaEncRepMatch(s *xzEncLZMA2, rep uint32, len uint32, posState uint32) {
+	state := s.lzma.state
+	if rep == 0 {
+		rcEncBit(&s.rc, &s.lzma.isRep0[state], 0)
+		var long uint32
+		if len != 1 {
+			long = 1
+		}
+		rcEncBit(&s.rc, &s.lzma.isRep0Long[state][posState], long)
+	} else {
+		dist := s.lzma.reps[rep]
+		rcEncBit(&s.rc, &s.lzma.isRep0[state], 1)
+		if rep == 1 {
+			rcEncBit(&s.rc, &s.lzma.isRep1[state], 0)
+		} else {
+			rcEncBit(&s.rc, &s.lzma.isRep1[state], 1)
+			rcEncBit(&s.rc, &s.lzma.isRep2[state], rep-2)
+			if rep == 3 {
+				s.lzma.reps[3] = s.lzma.reps[2]
+			}
+			s.lzma.reps[2] = s.lzma.reps[1]
+		}
+		s.lzma.reps[1] = s.lzma.reps[0]
+		s.lzma.reps[0] = dist
+	}
+	if len == 1 {
+		lzmaStateShortRep(&s.lzma.state)
+	} else {
+		lzmaEncLen(s, &s.lzma.repLenEnc, len, posState)
+		lzmaStateLongRep(&s.lzma.state)
+	}
+}
+
+/*
+ * Encode one LZMA symbol starting at the current position. back is
+ * lzmaBackLiteral for a literal, 0-3 for a repeated match, or the
+ * match distance (minus one) plus reps for a normal match.
+ */
+func lzmaEncSymbol(s *xzEncLZMA2, back uint32, len uint32) {
+	cur := s.mf.readPos - s.mf.readAhead
+	posState := uint32(s.lzma.pos) & s.lzma.posMask
+	state := s.lzma.state
+	if back == lzmaBackLiteral {
+		rcEncBit(&s.rc, &s.lzma.isMatch[state][posState], 0)
+		lzmaEncLiteral(s, cur)
+	} else {
+		rcEncBit(&s.rc, &s.lzma.isMatch[state][posState], 1)
+		if back < reps {
+			rcEncBit(&s.rc, &s.lzma.isRep[state], 1)
+			lzmaEncRepMatch(s, back, len, posState)
+		} else {
+			rcEncBit(&s.rc, &s.lzma.isRep[state], 0)
+			lzmaEncMatch(s, back-reps, len, posState)
+		}
+	}
+	s.mf.readAhead -= int(len)
+	s.lzma.pos += uint64(len)
+	s.lzma2.uncompressed += int(len)
+}
+
+/*
+ * Return true if the distance big is so much bigger than small that
+ * a match one byte shorter at distance small is likely to be cheaper.
+ */
+func changePair(small uint32, big uint32) bool {
+	return big>>7 > small
+}
+
+/*
+ * Choose the next symbol to encode using the fast heuristics of
+ * liblzma's LZMA_MODE_FAST. Returns back and len as expected by
+ * lzmaEncSymbol.
+ */
+func lzmaOptimumFast(s *xzEncLZMA2) (back uint32, len uint32) {
+	mf := s.mf
+	buf := mf.buf
+	niceLen := mf.niceLen
+	var count int
+	var lenMain uint32
+	if mf.readAhead == 0 {
+		count, lenMain = lzFind(mf)
+	} else {
+		count, lenMain = s.lzma.matchCount, s.lzma.longestMatchLen
+	}
+	cur := mf.readPos - 1
+	bufAvail := uint32(lzAvail(mf) + 1)
+	if bufAvail > matchLenMax {
+		bufAvail = matchLenMax
+	}
+	if bufAvail < 2 {
+		return lzmaBackLiteral, 1
+	}
+	/* Look for repeated matches; scan the previous four match distances */
+	var repLen, repIndex uint32
+	for i := uint32(0); i < reps; i++ {
+		b := cur - int(s.lzma.reps[i]) - 1
+		if buf[cur] != buf[b] || buf[cur+1] != buf[b+1] {
+			continue
+		}
+		l := lzMemcmplen(buf, cur, b, 2, bufAvail)
+		if l >= niceLen {
+			lzSkip(mf, int(l)-1)
+			return i, l
+		}
+		if l > repLen {
+			repIndex = i
+			repLen = l
+		}
+	}
+	/*
+	 * We didn't find a long enough repeated match. Encode it as a
+	 * normal match if the match length is at least niceLen.
+	 */
+	if lenMain >= niceLen {
+		lzSkip(mf, int(lenMain)-1)
+		return mf.matches[count-1].dist + reps, lenMain
+	}
+	var backMain uint32
+	if lenMain >= 2 {
+		backMain = mf.matches[count-1].dist
+		for count > 1 && lenMain == mf.matches[count-2].len+1 {
+			if !changePair(mf.matches[count-2].dist, backMain) {
+				break
+			}
+			count--
+			lenMain = mf.matches[count-1].len
+			backMain = mf.matches[count-1].dist
+		}
+		if lenMain == 2 && backMain >= 0x80 {
+			lenMain = 1
+		}
+	}
+	if repLen >= 2 {
+		if repLen+1 >= lenMain ||
+			repLen+2 >= lenMain && backMain > 1<<9 ||
+			repLen+3 >= lenMain && backMain > 1<<15 {
+			lzSkip(mf, int(repLen)-1)
+			return repIndex, repLen
+		}
+	}
+	if lenMain < 2 || bufAvail <= 2 {
+		return lzmaBackLiteral, 1
+	}
+	/*
+	 * Get the matches for the next byte. If we find a better match,
+	 * the current byte is encoded as a literal.
+	 */
+	s.lzma.matchCount, s.lzma.longestMatchLen = lzFind(mf)
+	if l := s.lzma.longestMatchLen; l >= 2 {
+		newDist := mf.matches[s.lzma.matchCount-1].dist
+		if l >= lenMain && newDist < backMain ||
+			l == lenMain+1 && !changePair(backMain, newDist) ||
+			l > lenMain+1 ||
+			l+1 >= lenMain && lenMain >= 3 && changePair(newDist, backMain) {
+			return lzmaBackLiteral, 1
+		}
+	}
+	cur++
+	limit := lenMain - 1
+	if limit < 2 {
+		limit = 2
+	}
+	for i := 0; i < reps; i++ {
+		b := cur - int(s.lzma.reps[i]) - 1
+		if lzMemcmplen(buf, cur, b, 0, limit) == limit {
+			return lzmaBackLiteral, 1
+		}
+	}
+	lzSkip(mf, int(lenMain)-2)
+	return backMain + reps, lenMain
+}
+
+/*
+ * Encode LZMA symbols until the current LZMA2 chunk is full or the
+ * encoder runs out of input. Return true if the chunk is full.
+ */
+func lzmaEncode(s *xzEncLZMA2) bool {
+	mf := s.mf
+	/* The first byte after a dictionary reset is always a literal. */
+	if s.lzma.pos == 0 && mf.readAhead == 0 && mf.readPos < mf.readLimit {
+		lzSkip(mf, 1)
+		lzmaEncSymbol(s, lzmaBackLiteral, 1)
+	}
+	for {
+		if s.lzma2.uncompressed >= lzma2UncompressedMax-matchLenMax ||
+			rcPending(&s.rc) >= lzma2ChunkMax-lzmaOutRequired {
+			return true
+		}
+		if mf.readPos >= mf.readLimit {
+			if !mf.finishing || mf.readAhead == 0 {
+				return false
+			}
+		}
+		back, len := lzmaOptimumFast(s)
+		lzmaEncSymbol(s, back, len)
+	}
+}
+
+/* from liblzma/lzma/lzma2_encoder.c **********************************/
+
+type xzEncLZMA2 struct {
+	rc   rcEnc
+	mf   *lzEncoder
+	lzma lzmaEnc
+	/* Encoder options */
+	opts lzma2Options
+	/* LZMA properties byte (lc/lp/pb) */
+	props byte
+	lzma2 struct {
+		/* Uncompressed size of the current chunk */
+		uncompressed int
+		/*
+		 * True if the next chunk must reset the dictionary. This
+		 * is true before the first chunk.
+		 */
+		needDictReset bool
+		/*
+		 * True if the next LZMA chunk must include the LZMA
+		 * properties. This is true before the first LZMA chunk.
+		 */
+		needProps bool
+		/* True if the next LZMA chunk must reset the LZMA state */
+		needStateReset bool
+	}
+}
+
+/*
+ * Append the current chunk to out, which is returned. If the chunk
+ * doesn't compress, it is written as uncompressed chunks instead.
+ */
+func lzma2Chunk(s *xzEncLZMA2, out []byte) []byte {
+	if s.lzma2.uncompressed == 0 {
+		return out
+	}
+	rcEncFlush(&s.rc)
+	compressed := len(s.rc.out)
+	uncompressed := s.lzma2.uncompressed
+	if compressed >= uncompressed {
+		/*
+		 * Bytes which have been run through the match finder but
+		 * not yet encoded are included in the uncompressed chunk.
+		 */
+		uncompressed += s.mf.readAhead
+		s.lzma.pos += uint64(s.mf.readAhead)
+		s.mf.readAhead = 0
+		data := s.mf.buf[s.mf.readPos-uncompressed : s.mf.readPos]
+		for len(data) > 0 {
+			n := len(data)
+			if n > lzma2ChunkMax {
+				n = lzma2ChunkMax
+			}
+			control := byte(0x02)
+			if s.lzma2.needDictReset {
+				control = 0x01
+			}
+			out = append(out, control, byte((n-1)>>8), byte(n-1))
+			out = append(out, data[:n]...)
+			data = data[n:]
+			s.lzma2.needDictReset = false
+		}
+		/*
+		 * The decoder won't have seen the LZMA symbols, so the
+		 * LZMA state must be reset before the next LZMA chunk.
+		 */
+		lzmaEncReset(&s.lzma)
+		s.lzma2.needStateReset = true
+	} else {
+		var control byte
+		switch {
+		case s.lzma2.needDictReset:
+			control = 0xe0
+		case s.lzma2.needProps:
+			control = 0xc0
+		case s.lzma2.needStateReset:
+			control = 0xa0
+		default:
+			control = 0x80
+		}
+		control |= byte((uncompressed - 1) >> 16)
+		out = append(out, control,
+			byte((uncompressed-1)>>8), byte(uncompressed-1),
+			byte((compressed-1)>>8), byte(compressed-1))
+		if s.lzma2.needProps {
+			out = append(out, s.props)
+		}
+		out = append(out, s.rc.out...)
+		s.lzma2.needDictReset = false
+		s.lzma2.needProps = false
+		s.lzma2.needStateReset = false
+	}
+	s.lzma2.uncompressed = 0
+	rcEncReset(&s.rc)
+	return out
+}
+
+/*
+ * Encode as much of the data in the window as possible, appending
+ * complete chunks to out, which is returned.
+ */
+func lzma2Encode(s *xzEncLZMA2, out []byte) []byte {
+	for lzmaEncode(s) {
+		out = lzma2Chunk(s, out)
+	}
+	return out
+}
+
+/*
+ * Encode in, appending any complete LZMA2 chunks to out, which is
+ * returned. Some of the input may be held back in the window until
+ * more input arrives or xzEncLZMA2Finish is called.
+ */
+func xzEncLZMA2Write(s *xzEncLZMA2, in []byte, out []byte) []byte {
+	for len(in) > 0 {
+		n := lzFill(s.mf, in)
+		in = in[n:]
+		out = lzma2Encode(s, out)
+	}
+	return out
+}
+
+/*
+ * Encode any remaining input and append the final chunk and the
+ * LZMA2 end marker to out, which is returned.
+ */
+func xzEncLZMA2Finish(s *xzEncLZMA2, out []byte) []byte {
+	s.mf.finishing = true
+	lzSetLimit(s.mf)
+	out = lzma2Encode(s, out)
+	out = lzma2Chunk(s, out)
+	return append(out, 0x00)
+}
+
+/* Return the LZMA2 properties byte that encodes dictSize. */
+func lzma2DictProps(dictSize uint32) byte {
+	var props byte
+	for props < 40 && uint32(2|props&1)<<(props>>1+11) < dictSize {
+		props++
+	}
+	return props
+}
+
+/*
+ * Allocate memory for an LZMA2 encoder. xzEncLZMA2Reset must be used
+ * before calling xzEncLZMA2Write.
+ */
+func xzEncLZMA2Create(opts lzma2Options) *xzEncLZMA2 {
+	s := new(xzEncLZMA2)
+	s.opts = opts
+	s.mf = lzCreate(opts.dictSize, opts.niceLen, opts.depth)
+	s.rc.out = make([]byte, 0, lzma2ChunkMax+lzmaOutRequired)
+	s.props = lzmaEncProps(&s.lzma, opts.lc, opts.lp, opts.pb)
+	return s
+}
+
+/* Reset the encoder so that it is ready to encode a new LZMA2 stream. */
+func xzEncLZMA2Reset(s *xzEncLZMA2) {
+	lzReset(s.mf)
+	lzmaEncReset(&s.lzma)
+	s.lzma.pos = 0
+	s.lzma.matchCount = 0
+	s.lzma.longestMatchLen = 0
+	rcEncReset(&s.rc)
+	s.lzma2.uncompressed = 0
+	s.lzma2.needDictReset = true
+	s.lzma2.needProps = true
+	s.lzma2.needStateReset = false
+}
@@ -0,0 +1,354 @@
+/*
+ * .xz Stream encoder
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"crypto/sha256"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+)
+
+/*
+ * See the .xz file format specification at
+ * http://tukaani.org/xz/xz-file-format.txt
+ * to understand the container format.
+ */
+
+/* xzEnc holds the XZ encoder state */
+type xzEnc struct {
+	/* Type of the integrity check */
+	checkType CheckID
+	/* Hash used to calculate the Check field, or nil for CheckNone */
+	check hash.Hash
+	/* Hashes which may be used as check, allocated when first needed */
+	checkCRC32  hash.Hash
+	checkCRC64  hash.Hash
+	checkSHA256 hash.Hash
+	/* LZMA2 encoder, which is the last filter */
+	lzma2 *xzEncLZMA2
+	/* BCJ filter preceding LZMA2, or nil if LZMA2 is the only filter */
+	bcj *xzEncBCJ
+	/* Start offset of the BCJ filter */
+	bcjStart int
+	/* Input of the BCJ filter which has not yet been converted */
+	bcjBuf []byte
+	/* Information collected about the current Block */
+	block struct {
+		/* True if a Block has been started but not finished */
+		open bool
+		/* Size of the Block Header field */
+		headerSize int
+		/* Compressed and uncompressed sizes of the current Block */
+		compressed   vliType
+		uncompressed vliType
+	}
+	/* Records of the Blocks which have been finished */
+	index []xzIndexRecord
+	/* Compressed Data of the Block being encoded by xzEncBlock */
+	data []byte
+}
+
+/* Append a variable-length integer to out, which is returned. */
+func encVLI(out []byte, vli vliType) []byte {
+	for vli >= 0x80 {
+		out = append(out, byte(vli)|0x80)
+		vli >>= 7
+	}
+	return append(out, byte(vli))
+}
+
+/* Append the Stream Header to out, which is returned. */
+func encStreamHeader(out []byte, checkType CheckID) []byte {
+	out = append(out, headerMagic...)
+	flags := []byte{0x00, byte(checkType)}
+	out = append(out, flags...)
+	var crc [4]byte
+	putLE32(crc32.ChecksumIEEE(flags), crc[:])
+	return append(out, crc[:]...)
+}
+
+/*
+ * Append a Block Header to out, which is returned. The filters are
+ * the BCJ filter bcj with start offset bcjStart, unless bcj is nil,
+ * followed by LZMA2. The sizes are omitted from the header if they
+ * are vliUnknown.
+ */
+func encBlockHeader(out []byte, bcj *xzEncBCJ, bcjStart int, dictProps byte,
+	compressed vliType, uncompressed vliType) []byte {
+	start := len(out)
+	/* Block Header Size is filled in below */
+	out = append(out, 0x00, 0x00)
+	if bcj != nil {
+		/* Number of filters - 1 */
+		out[start+1] |= 0x01
+	}
+	if compressed != vliUnknown {
+		out[start+1] |= 0x40
+		out = encVLI(out, compressed)
+	}
+	if uncompressed != vliUnknown {
+		out[start+1] |= 0x80
+		out = encVLI(out, uncompressed)
+	}
+	/* Filter Flags for BCJ, without properties for a zero offset */
+	if bcj != nil {
+		if bcjStart == 0 {
+			out = append(out, byte(bcj.typ), 0x00)
+		} else {
+			var props [4]byte
+			putLE32(uint32(bcjStart), props[:])
+			out = append(out, byte(bcj.typ), 0x04)
+			out = append(out, props[:]...)
+		}
+	}
+	/* Filter Flags for LZMA2 */
+	out = append(out, byte(idLZMA2), 0x01, dictProps)
+	/* Header Padding */
+	for (len(out)-start)&3 != 0 {
+		out = append(out, 0x00)
+	}
+	/* The stored size excludes the CRC32 and is divided by four */
+	out[start] = byte((len(out) - start) / 4)
+	var crc [4]byte
+	putLE32(crc32.ChecksumIEEE(out[start:]), crc[:])
+	return append(out, crc[:]...)
+}
+
+/*
+ * Append the Index and the Stream Footer to out, which is returned.
+ */
+func encIndex(out []byte, index []xzIndexRecord, checkType CheckID) []byte {
+	start := len(out)
+	/* Index Indicator */
+	out = append(out, 0x00)
+	out = encVLI(out, vliType(len(index)))
+	for _, r := range index {
+		out = encVLI(out, r.unpadded)
+		out = encVLI(out, r.uncompressed)
+	}
+	/* Index Padding */
+	for (len(out)-start)&3 != 0 {
+		out = append(out, 0x00)
+	}
+	var buf [4]byte
+	putLE32(crc32.ChecksumIEEE(out[start:]), buf[:])
+	out = append(out, buf[:]...)
+	/* Stream Footer */
+	footer := make([]byte, streamHeaderSize)
+	putLE32(uint32((len(out)-start)/4-1), footer[4:])
+	footer[8] = 0x00
+	footer[9] = byte(checkType)
+	putLE32(crc32.ChecksumIEEE(footer[4:10]), footer)
+	copy(footer[10:], footerMagic)
+	return append(out, footer...)
+}
+
+/* Append the Check field of the current Block to out, which is returned */
+func encCheck(s *xzEnc, out []byte) []byte {
+	if s.check == nil {
+		return out
+	}
+	sum := s.check.Sum(nil)
+	if s.checkType == CheckCRC32 || s.checkType == CheckCRC64 {
+		// CRC32/64 - reverse slice
+		for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+			sum[i], sum[j] = sum[j], sum[i]
+		}
+	}
+	s.check.Reset()
+	return append(out, sum...)
+}
+
+/*
+ * Select the check type used for the Blocks that follow. Returns
+ * xzUnsupportedCheck if the check type cannot be calculated.
+ */
+func xzEncSetCheck(s *xzEnc, checkType CheckID) xzRet {
+	switch checkType {
+	case CheckNone:
+		s.check = nil
+	case CheckCRC32:
+		if s.checkCRC32 == nil {
+			s.checkCRC32 = crc32.NewIEEE()
+		}
+		s.check = s.checkCRC32
+	case CheckCRC64:
+		if s.checkCRC64 == nil {
+			s.checkCRC64 = crc64.New(xzCRC64Table)
+		}
+		s.check = s.checkCRC64
+	case CheckSHA256:
+		if s.checkSHA256 == nil {
+			s.checkSHA256 = sha256.New()
+		}
+		s.check = s.checkSHA256
+	default:
+		return xzUnsupportedCheck
+	}
+	if s.check != nil {
+		s.check.Reset()
+	}
+	s.checkType = checkType
+	return xzOK
+}
+
+/*
+ * Select the BCJ filter preceding LZMA2 in the Blocks that follow and
+ * its start offset, which are checked as by xzEncBCJReset. An ID of
+ * zero selects no BCJ filter. Returns xzOptionsError if the filter or
+ * offset is not supported.
+ */
+func xzEncSetBCJ(s *xzEnc, id xzFilterID, offset int) xzRet {
+	if id == 0 {
+		s.bcj = nil
+		return xzOK
+	}
+	bcj := new(xzEncBCJ)
+	if ret := xzEncBCJReset(bcj, id, offset); ret != xzOK {
+		return ret
+	}
+	s.bcj = bcj
+	s.bcjStart = offset
+	return xzOK
+}
+
+/*
+ * Start a new stream using the given check type, appending the
+ * Stream Header to out, which is returned. Returns xzUnsupportedCheck
+ * if the check type cannot be calculated.
+ */
+func xzEncStreamStart(s *xzEnc, out []byte, checkType CheckID) ([]byte, xzRet) {
+	if ret := xzEncSetCheck(s, checkType); ret != xzOK {
+		return out, ret
+	}
+	s.index = s.index[:0]
+	s.block.open = false
+	return encStreamHeader(out, checkType), xzOK
+}
+
+/*
+ * Compress in, appending the output to out, which is returned. A
+ * Block is started if needed.
+ */
+func xzEncBlockWrite(s *xzEnc, in []byte, out []byte) []byte {
+	if !s.block.open {
+		xzEncLZMA2Reset(s.lzma2)
+		if s.bcj != nil {
+			xzEncBCJReset(s.bcj, s.bcj.typ, s.bcjStart)
+			s.bcjBuf = s.bcjBuf[:0]
+		}
+		start := len(out)
+		out = encBlockHeader(out, s.bcj, s.bcjStart,
+			lzma2DictProps(s.lzma2.opts.dictSize), vliUnknown, vliUnknown)
+		s.block.headerSize = len(out) - start
+		s.block.compressed = 0
+		s.block.uncompressed = 0
+		s.block.open = true
+	}
+	if s.check != nil {
+		_, _ = s.check.Write(in)
+	}
+	start := len(out)
+	if s.bcj == nil {
+		out = xzEncLZMA2Write(s.lzma2, in, out)
+	} else {
+		/*
+		 * The BCJ filter converts in place, and may need the
+		 * bytes which follow to convert the last few.
+		 */
+		s.bcjBuf = append(s.bcjBuf, in...)
+		filtered := xzEncBCJRun(s.bcj, s.bcjBuf)
+		out = xzEncLZMA2Write(s.lzma2, s.bcjBuf[:filtered], out)
+		s.bcjBuf = s.bcjBuf[:copy(s.bcjBuf, s.bcjBuf[filtered:])]
+	}
+	s.block.compressed += vliType(len(out) - start)
+	s.block.uncompressed += vliType(len(in))
+	return out
+}
+
+/*
+ * Finish the current Block, if any, appending the remaining
+ * Compressed Data, Block Padding and Check to out, which is returned.
+ */
+func xzEncBlockFinish(s *xzEnc, out []byte) []byte {
+	if !s.block.open {
+		return out
+	}
+	start := len(out)
+	if s.bcj != nil && len(s.bcjBuf) > 0 {
+		/* As for the decoder, the last bytes are left unconverted */
+		out = xzEncLZMA2Write(s.lzma2, s.bcjBuf, out)
+		s.bcjBuf = s.bcjBuf[:0]
+	}
+	out = xzEncLZMA2Finish(s.lzma2, out)
+	s.block.compressed += vliType(len(out) - start)
+	s.index = append(s.index, xzIndexRecord{
+		unpadded: vliType(s.block.headerSize) + s.block.compressed +
+			vliType(checkSizes[s.checkType]),
+		uncompressed: s.block.uncompressed,
+	})
+	/* Block Padding */
+	for s.block.compressed&3 != 0 {
+		out = append(out, 0x00)
+		s.block.compressed++
+	}
+	out = encCheck(s, out)
+	s.block.open = false
+	return out
+}
+
+/*
+ * Append a complete Block holding in to out, which is returned along
+ * with the Index Record of the Block. Unlike xzEncBlockWrite the
+ * Compressed Size and Uncompressed Size fields are stored in the
+ * Block Header, so the Compressed Data is collected in s.data before
+ * being appended to out. The Block is not added to s.index. Only
+ * LZMA2 is used, whatever BCJ filter is selected.
+ */
+func xzEncBlock(s *xzEnc, in []byte, out []byte) ([]byte, xzIndexRecord) {
+	xzEncLZMA2Reset(s.lzma2)
+	s.data = xzEncLZMA2Write(s.lzma2, in, s.data[:0])
+	s.data = xzEncLZMA2Finish(s.lzma2, s.data)
+	start := len(out)
+	out = encBlockHeader(out, nil, 0, lzma2DictProps(s.lzma2.opts.dictSize),
+		vliType(len(s.data)), vliType(len(in)))
+	headerSize := len(out) - start
+	out = append(out, s.data...)
+	/* Block Padding */
+	for (len(out)-start)&3 != 0 {
+		out = append(out, 0x00)
+	}
+	if s.check != nil {
+		_, _ = s.check.Write(in)
+	}
+	out = encCheck(s, out)
+	return out, xzIndexRecord{
+		unpadded: vliType(headerSize+len(s.data)) +
+			vliType(checkSizes[s.checkType]),
+		uncompressed: vliType(len(in)),
+	}
+}
+
+/*
+ * Finish the stream, appending the rest of the current Block, the
+ * Index and the Stream Footer to out, which is returned.
+ */
+func xzEncStreamFinish(s *xzEnc, out []byte) []byte {
+	out = xzEncBlockFinish(s, out)
+	return encIndex(out, s.index, s.checkType)
+}
+
+/* Allocate and initialize an XZ encoder state. */
+func xzEncInit(opts lzma2Options) *xzEnc {
+	s := new(xzEnc)
+	s.lzma2 = xzEncLZMA2Create(opts)
+	return s
+}
@@ -0,0 +1,48 @@
+/*
+ * Package xz Go Error type
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import "fmt"
+
+// An Error is returned by Reader when decompression fails. It
+// describes the problem found and where in the file it was found.
+//
+// Err holds one of the package specific errors, such as ErrData, which
+// Unwrap returns. To test for a particular kind of failure use, for
+// example, errors.Is(err, xz.ErrData).
+type Error struct {
+	Err                error  // the package specific error
+	Reason             string // the specific problem found, if known
+	Offset             int64  // offset in the input at which it was found
+	Stream             int    // stream number, counting from 0
+	Block              int    // block number in the stream, or -1
+	UncompressedOffset int64  // offset in the uncompressed data
+	MemRequired        uint64 // memory needed, if Err is ErrMemlimit
+}
+
+func (e *Error) Error() string {
+	s := e.Err.Error()
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	if e.MemRequired != 0 {
+		s += fmt.Sprintf(", %d bytes of memory needed", e.MemRequired)
+	}
+	s += fmt.Sprintf(" (offset %d, stream %d", e.Offset, e.Stream)
+	if e.Block >= 0 {
+		s += fmt.Sprintf(", block %d", e.Block)
+	}
+	return s + fmt.Sprintf(", uncompressed offset %d)", e.UncompressedOffset)
+}
+
+// Unwrap returns e.Err.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,104 @@
+/*
+ * Package xz Error tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/xi2/xz"
+)
+
+func TestErrorDetails(t *testing.T) {
+	for _, test := range []struct {
+		file   string
+		err    error
+		reason string
+		offset int64
+		stream int
+		block  int
+		uOff   int64
+	}{
+		{"bad-0-header_magic.xz",
+			xz.ErrFormat, "stream header magic mismatch", 12, 0, -1, 0},
+		{"bad-0cat-header_magic.xz",
+			xz.ErrFormat, "stream header magic mismatch", 44, 1, -1, 0},
+		{"bad-0pad-empty.xz",
+			xz.ErrData, "stream padding size not a multiple of four",
+			37, 1, -1, 0},
+		{"bad-1-block_header-3.xz",
+			xz.ErrData, "block header CRC32 mismatch", 24, 0, 0, 0},
+		{"bad-1-check-crc32.xz",
+			xz.ErrData, "CRC32 check mismatch", 47, 0, 0, 13},
+		{"bad-1-lzma2-6.xz",
+			xz.ErrData, "LZMA2 invalid control byte", 34, 0, 0, 6},
+		{"bad-2-compressed_data_padding.xz",
+			xz.ErrData, "non-zero block padding", 35, 0, 0, 6},
+		{"bad-2-index-1.xz",
+			xz.ErrData, "index records do not match blocks", 76, 0, -1, 13},
+		{"bad-0-nonempty_index.xz",
+			xz.ErrData, "index record count mismatch", 14, 0, -1, 0},
+		{"bad-0-empty-truncated.xz",
+			xz.ErrBuf, "unexpected end of input", 31, 0, -1, 0},
+	} {
+		data, err := readTestFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range []io.Reader{
+			bytes.NewReader(data),
+			iotest.OneByteReader(bytes.NewReader(data)),
+		} {
+			z, err := xz.NewReader(r, 0)
+			if err == nil {
+				_, err = ioutil.ReadAll(z)
+			}
+			var e *xz.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("%s: wanted *xz.Error, got: %v", test.file, err)
+			}
+			want := xz.Error{
+				Err:                test.err,
+				Reason:             test.reason,
+				Offset:             test.offset,
+				Stream:             test.stream,
+				Block:              test.block,
+				UncompressedOffset: test.uOff,
+			}
+			if *e != want {
+				t.Fatalf("%s: wanted error: %+v, got: %+v",
+					test.file, want, *e)
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("%s: error does not match %v", test.file, test.err)
+			}
+		}
+	}
+}
+
+func TestErrorReadError(t *testing.T) {
+	// errors from the underlying reader are returned unchanged
+	data, err := readTestFile("words.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := xz.NewReader(
+		iotest.TimeoutReader(iotest.HalfReader(bytes.NewReader(data))), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(z); err != iotest.ErrTimeout {
+		t.Fatalf("wanted error: %v, got: %v", iotest.ErrTimeout, err)
+	}
+}
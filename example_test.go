@@ -93,3 +93,30 @@ func ExampleReader_Multistream() {
 	// Read second stream
 	// No more streams
 }
+
+func ExampleNewWriter() {
+	// compress some data into a buffer
+	var buf bytes.Buffer
+	w := xz.NewWriter(&buf)
+	_, err := io.WriteString(w, "Hello\nWorld!\n")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Close must be called to write the end of the XZ stream
+	err = w.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// decompress the data again and write it to os.Stdout
+	r, err := xz.NewReader(&buf, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = io.Copy(os.Stdout, r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// Hello
+	// World!
+}
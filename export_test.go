@@ -0,0 +1,45 @@
+/*
+ * Package xz internals exposed to the tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"io"
+	"reflect"
+)
+
+// AddWriterPos advances the uncompressed position of the LZMA encoder
+// of w, which must have been written to, by n bytes. n must be a
+// multiple of 16 so that the position bits used by the encoder are
+// unchanged. This lets the tests take the position past 4 GiB without
+// compressing that much data.
+func AddWriterPos(w *Writer, n uint64) {
+	w.enc.lzma2.lzma.pos += n
+}
+
+// MemUsage lists the memory counted against a memory limit for each
+// kind of structure along with the structure's actual size.
+var MemUsage = []struct {
+	Name    string
+	Counted uintptr
+	Size    uintptr
+}{
+	{"decoder", memUsageBase,
+		reflect.TypeOf(xzDec{}).Size() + reflect.TypeOf(xzDecLZMA2{}).Size()},
+	{"BCJ", memUsageBCJ, reflect.TypeOf(xzDecBCJ{}).Size()},
+	{"Delta", memUsageDelta, reflect.TypeOf(xzDecDelta{}).Size()},
+	{"Reader", memUsageReader, reflect.TypeOf(Reader{}).Size()},
+	{"blockReader", memUsageReader,
+		reflect.TypeOf(blockReader{}).Size() +
+			reflect.TypeOf(io.SectionReader{}).Size()},
+	{"Index stream", memUsageIndexStream, reflect.TypeOf(xzIndexStream{}).Size()},
+	{"Index block", memUsageIndexBlock,
+		reflect.TypeOf(xzIndexRecord{}).Size() +
+			reflect.TypeOf(xzIndexBlock{}).Size()},
+}
@@ -0,0 +1,96 @@
+/*
+ * Package xz Go Index API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import "io"
+
+// An Index describes the streams and blocks of an XZ file. It holds
+// the information shown by "xz --list".
+type Index struct {
+	Streams          []StreamInfo // the streams in file order
+	CompressedSize   int64        // size of the file
+	UncompressedSize int64        // total size of the uncompressed data
+}
+
+// StreamInfo describes a single stream of an XZ file.
+type StreamInfo struct {
+	Header
+	Offset             int64       // offset of the stream in the file
+	UncompressedOffset int64       // offset of the stream's data
+	CompressedSize     int64       // size of the stream excluding padding
+	UncompressedSize   int64       // size of the stream's data
+	IndexSize          int64       // size of the stream's Index field
+	Padding            int64       // size of the padding after the stream
+	Blocks             []BlockInfo // the stream's blocks in file order
+}
+
+// BlockInfo describes a single block of an XZ stream. Offsets are
+// relative to the start of the file and of the uncompressed data
+// respectively.
+type BlockInfo struct {
+	Offset             int64 // offset of the block in the file
+	UncompressedOffset int64 // offset of the block's data
+	CompressedSize     int64 // size of the block including padding
+	UnpaddedSize       int64 // size of the block excluding padding
+	UncompressedSize   int64 // size of the block's data
+}
+
+// ReadIndex reads the Index of every stream of the XZ file held in
+// the first size bytes of r. The stream headers and footers are
+// checked but the blocks are not read or decompressed.
+func ReadIndex(r io.ReaderAt, size int64) (*Index, error) {
+	x, ret, err := decFileIndex(r, size, 0)
+	if err != nil {
+		return nil, err
+	}
+	if ret != xzOK {
+		return nil, retError(ret)
+	}
+	return newIndex(x), nil
+}
+
+// newIndex returns the public form of x.
+func newIndex(x *xzIndex) *Index {
+	idx := &Index{
+		Streams:          make([]StreamInfo, len(x.streams)),
+		UncompressedSize: x.uncompressed,
+	}
+	blocks := x.blocks
+	for i, st := range x.streams {
+		si := &idx.Streams[i]
+		si.CheckType = st.checkType
+		si.Offset = st.offset
+		si.UncompressedOffset = st.uOffset
+		si.CompressedSize = st.compressed
+		si.UncompressedSize = st.uncompressed
+		si.IndexSize = st.indexSize
+		si.Padding = st.padding
+		si.Blocks = make([]BlockInfo, len(st.records))
+		for j := range si.Blocks {
+			blk := blocks[j]
+			si.Blocks[j] = BlockInfo{
+				Offset:             blk.offset,
+				UncompressedOffset: blk.uOffset,
+				CompressedSize:     (blk.unpadded + 3) &^ 3,
+				UnpaddedSize:       blk.unpadded,
+				UncompressedSize:   blk.uncompressed,
+			}
+		}
+		blocks = blocks[len(st.records):]
+		idx.CompressedSize += st.compressed + st.padding
+	}
+	return idx
+}
+
+// Index returns a description of the streams and blocks of the file
+// being read.
+func (z *ReaderAt) Index() *Index {
+	return newIndex(z.index)
+}
@@ -0,0 +1,109 @@
+/*
+ * Package xz Index tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+// Note that the values below were generated with "xz --robot -lvv"
+// from XZ Utils.
+
+func TestReadIndex(t *testing.T) {
+	file, err := readTestFile("words-blocks.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := xz.ReadIndex(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &xz.Index{
+		Streams: []xz.StreamInfo{{
+			Header:             xz.Header{CheckType: xz.CheckCRC32},
+			Offset:             0,
+			UncompressedOffset: 0,
+			CompressedSize:     47444,
+			UncompressedSize:   89413,
+			IndexSize:          36,
+			Padding:            0,
+			Blocks: []xz.BlockInfo{
+				{12, 0, 8652, 8650, 16384},
+				{8664, 16384, 8688, 8688, 16384},
+				{17352, 32768, 8640, 8637, 16384},
+				{25992, 49152, 8616, 8614, 16384},
+				{34608, 65536, 8600, 8597, 16384},
+				{43208, 81920, 4188, 4187, 7493},
+			},
+		}},
+		CompressedSize:   47444,
+		UncompressedSize: 89413,
+	}
+	if !reflect.DeepEqual(idx, want) {
+		t.Fatalf("wanted: %+v, got: %+v\n", want, idx)
+	}
+}
+
+func TestReadIndexPadding(t *testing.T) {
+	file, err := readTestFile("good-0catpad-empty.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := xz.ReadIndex(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &xz.Index{
+		Streams: []xz.StreamInfo{
+			{
+				Header:         xz.Header{CheckType: xz.CheckCRC32},
+				Offset:         0,
+				CompressedSize: 32,
+				IndexSize:      8,
+				Padding:        4,
+				Blocks:         []xz.BlockInfo{},
+			},
+			{
+				Header:         xz.Header{CheckType: xz.CheckCRC32},
+				Offset:         36,
+				CompressedSize: 32,
+				IndexSize:      8,
+				Padding:        0,
+				Blocks:         []xz.BlockInfo{},
+			},
+		},
+		CompressedSize: 68,
+	}
+	if !reflect.DeepEqual(idx, want) {
+		t.Fatalf("wanted: %+v, got: %+v\n", want, idx)
+	}
+}
+
+func TestReadIndexErrors(t *testing.T) {
+	for _, f := range []testFile{
+		{file: "bad-0-backward_size.xz", err: xz.ErrData},
+		{file: "bad-0-header_magic.xz", err: xz.ErrFormat},
+		{file: "bad-0-empty-truncated.xz", err: xz.ErrData},
+		{file: "bad-2-index-3.xz", err: xz.ErrData},
+		{file: "bad-2-index-5.xz", err: xz.ErrData},
+	} {
+		file, err := readTestFile(f.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = xz.ReadIndex(bytes.NewReader(file), int64(len(file))); err != f.err {
+			t.Fatalf("%s: wanted error: %v, got: %v\n", f.file, f.err, err)
+		}
+	}
+}
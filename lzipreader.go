@@ -0,0 +1,313 @@
+/*
+ * Package xz Go LzipReader API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+/*
+ * See the lzip file format specification at
+ * http://www.nongnu.org/lzip/manual/lzip_manual.html#File-format
+ * to understand the container format.
+ */
+const (
+	lzipMagic       = "LZIP"
+	lzipVersion     = 1
+	lzipHeaderSize  = 6
+	lzipTrailerSize = 20
+	/* LZMA properties of lzip members: lc = 3, lp = 0, pb = 2 */
+	lzipProps = (2*5+0)*9 + 3
+	/* Largest dictionary size allowed in a member header */
+	lzipDictMax = 1 << 29
+)
+
+// type of LzipReader.sequence
+type lzipSeq int
+
+const (
+	seqLzipHeader lzipSeq = iota
+	seqLzipData
+	seqLzipTrailer
+)
+
+// An LzipReader is an io.Reader that can be used to retrieve
+// uncompressed data from an lzip file, as created by the lzip
+// program.
+//
+// In general, an lzip file is a sequence of members, each of which
+// has its own CRC32 and sizes in a trailer. Reads from the
+// LzipReader return the concatenation of the uncompressed data of
+// each.
+type LzipReader struct {
+	r           io.Reader       // the wrapped io.Reader
+	multistream bool            // true if reader is in multistream mode
+	rEOF        bool            // true after io.EOF received on r
+	dEOF        bool            // true after decoder has completed
+	sequence    lzipSeq         // position in the current member
+	members     int             // number of members read so far
+	in          [inBufSize]byte // backing array for buf.in
+	buf         *xzBuf          // decoder input/output buffers
+	temp        struct {
+		buf [lzipTrailerSize]byte // member header or trailer
+		pos int                   // number of bytes in buf
+	}
+	dec          *xzDecLZMA2 // decoder state
+	crc          hash.Hash32 // CRC32 of the member's data
+	compressed   int64       // size of the member's LZMA stream
+	uncompressed int64       // size of the member's data
+	err          error       // the result of the last decoder call
+}
+
+// NewLzipReader creates a new LzipReader reading from r. The
+// decompressor will use a dictionary size up to dictMax bytes in
+// size. Passing a value of zero sets dictMax to DefaultDictMax. If an
+// individual member requires a dictionary size greater than dictMax
+// in order to decompress, Read will return ErrMemlimit.
+//
+// Due to internal buffering, the LzipReader may read more data than
+// necessary from r.
+func NewLzipReader(r io.Reader, dictMax uint32) (*LzipReader, error) {
+	if dictMax == 0 {
+		dictMax = DefaultDictMax
+	}
+	z := &LzipReader{
+		buf: &xzBuf{},
+		dec: xzDecLZMA2Create(dictMax),
+		crc: crc32.NewIEEE(),
+	}
+	err := z.Reset(r)
+	return z, err
+}
+
+// fillTemp copies bytes from the input buffer to z.temp.buf until it
+// holds size bytes, returning true once it does.
+func (z *LzipReader) fillTemp(size int) bool {
+	n := copy(z.temp.buf[z.temp.pos:size], z.buf.in[z.buf.inPos:])
+	z.buf.inPos += n
+	z.temp.pos += n
+	return z.temp.pos == size
+}
+
+// trailingData discards the input following the last member, which
+// is ignored as it is by lzip.
+func (z *LzipReader) trailingData() {
+	z.buf.inPos = len(z.buf.in)
+	z.rEOF = true
+	z.dEOF = true
+}
+
+// decode decodes the members of the file, returning xzStreamEnd at the
+// end of each member. Like xzDecRun, it returns xzBufError if no
+// progress is possible.
+func (z *LzipReader) decode() xzRet {
+	b := z.buf
+	switch z.sequence {
+	case seqLzipHeader:
+		if !z.fillTemp(lzipHeaderSize) {
+			switch {
+			case !z.rEOF:
+				return xzOK
+			case z.members > 0:
+				z.trailingData()
+				return xzOK
+			}
+			return xzBufError
+		}
+		z.temp.pos = 0
+		hdr := z.temp.buf[:lzipHeaderSize]
+		if string(hdr[:len(lzipMagic)]) != lzipMagic {
+			if z.members > 0 {
+				z.trailingData()
+				return xzOK
+			}
+			return xzFormatError
+		}
+		if hdr[4] != lzipVersion {
+			return xzOptionsError
+		}
+		/*
+		 * The dictionary size is 2^n minus (2^n)/16 times the
+		 * value of the top three bits.
+		 */
+		n := hdr[5] & 0x1f
+		if n < 12 || n > 29 {
+			return xzDataError
+		}
+		dictSize := uint32(1) << n
+		dictSize -= dictSize / 16 * uint32(hdr[5]>>5)
+		if dictSize < lzmaDictMin || dictSize > lzipDictMax {
+			return xzDataError
+		}
+		if ret := xzDecLZMA1Reset(
+			z.dec, lzipProps, dictSize, vliUnknown); ret != xzOK {
+			return ret
+		}
+		z.crc.Reset()
+		z.compressed = 0
+		z.uncompressed = 0
+		z.sequence = seqLzipData
+		fallthrough
+	case seqLzipData:
+		if z.rEOF && b.inPos == len(b.in) {
+			xzDecLZMA1InputEnd(z.dec)
+		}
+		inStart := b.inPos
+		outStart := b.outPos
+		ret := xzDecLZMA1Run(z.dec, b)
+		_, _ = z.crc.Write(b.out[outStart:b.outPos])
+		z.compressed += int64(b.inPos - inStart)
+		z.uncompressed += int64(b.outPos - outStart)
+		if ret == xzOK && z.rEOF &&
+			inStart == b.inPos && outStart == b.outPos {
+			return xzBufError
+		}
+		if ret != xzStreamEnd {
+			return ret
+		}
+		/*
+		 * The decoder may hold input following the LZMA
+		 * stream. Put it back in front of the input buffer.
+		 */
+		unused := xzDecLZMA1Unused(z.dec)
+		z.compressed -= int64(len(unused))
+		in := make([]byte, len(unused)+len(b.in)-b.inPos)
+		copy(in[copy(in, unused):], b.in[b.inPos:])
+		b.in = in
+		b.inPos = 0
+		z.sequence = seqLzipTrailer
+		fallthrough
+	case seqLzipTrailer:
+		if !z.fillTemp(lzipTrailerSize) {
+			if z.rEOF {
+				return xzBufError
+			}
+			return xzOK
+		}
+		z.temp.pos = 0
+		t := z.temp.buf[:]
+		if getLE32(t) != z.crc.Sum32() ||
+			int64(getLE32(t[4:]))|int64(getLE32(t[8:]))<<32 !=
+				z.uncompressed ||
+			int64(getLE32(t[12:]))|int64(getLE32(t[16:]))<<32 !=
+				lzipHeaderSize+z.compressed+lzipTrailerSize {
+			return xzDataError
+		}
+		z.members++
+		z.sequence = seqLzipHeader
+		return xzStreamEnd
+	}
+	/* Never reached */
+	return xzOK
+}
+
+func (z *LzipReader) Read(p []byte) (n int, err error) {
+	// restore err
+	err = z.err
+	// set decoder output buffer to p
+	z.buf.out = p
+	z.buf.outPos = 0
+	for {
+		// update n
+		n = z.buf.outPos
+		// if last call to decoder ended with an error, return that error
+		if err != nil {
+			break
+		}
+		// if decoder has finished, return with err == io.EOF
+		if z.dEOF {
+			err = io.EOF
+			break
+		}
+		// if p full, return with err == nil, unless we have not yet
+		// read the member header with Read(nil)
+		if n == len(p) && z.sequence != seqLzipHeader {
+			break
+		}
+		// if needed, read more data from z.r
+		if z.buf.inPos == len(z.buf.in) && !z.rEOF {
+			rn, e := z.r.Read(z.in[:])
+			if e != nil && e != io.EOF {
+				// read error
+				err = e
+				break
+			}
+			if e == io.EOF {
+				z.rEOF = true
+			}
+			// set new input buffer in z.buf
+			z.buf.in = z.in[:rn]
+			z.buf.inPos = 0
+		}
+		// decode more data
+		switch ret := z.decode(); ret {
+		case xzOK:
+			// no action needed
+		case xzStreamEnd:
+			if !z.multistream ||
+				z.rEOF && z.buf.inPos == len(z.buf.in) {
+				z.dEOF = true
+			}
+		default:
+			err = retError(ret)
+		}
+		// save err
+		z.err = err
+	}
+	return
+}
+
+// Multistream controls whether the reader is operating in multistream
+// mode, with the same effect as for Reader. If enabled (the default),
+// the members of the file are read one after another. If disabled,
+// Read returns io.EOF at the end of each member; to start the next
+// member call z.Reset(nil) followed by z.Multistream(false).
+func (z *LzipReader) Multistream(ok bool) {
+	z.multistream = ok
+}
+
+// Reset, for non-nil values of io.Reader r, discards the LzipReader
+// z's state and makes it equivalent to the result of its original
+// state from NewLzipReader, but reading from r instead. This permits
+// reusing an LzipReader rather than allocating a new one.
+//
+// As for Reader, z.Reset(nil) keeps r unchanged. If the LzipReader
+// was at the end of a member it is then ready to read any follow on
+// members, and if there are none z.Reset(nil) returns io.EOF.
+func (z *LzipReader) Reset(r io.Reader) error {
+	switch {
+	case r == nil:
+		z.multistream = true
+		if !z.dEOF {
+			return nil
+		}
+		if z.rEOF && z.buf.inPos == len(z.buf.in) {
+			return io.EOF
+		}
+		z.dEOF = false
+		_, err := z.Read(nil) // read member header
+		return err
+	default:
+		z.r = r
+		z.multistream = true
+		z.rEOF = false
+		z.dEOF = false
+		z.sequence = seqLzipHeader
+		z.members = 0
+		z.temp.pos = 0
+		z.buf.in = nil
+		z.buf.inPos = 0
+		z.err = nil
+		_, err := z.Read(nil) // read member header
+		return err
+	}
+}
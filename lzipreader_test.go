@@ -0,0 +1,148 @@
+/*
+ * Package xz LzipReader tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/xi2/xz"
+)
+
+// words.lz has two members, holding the first 30000 bytes of words
+// and the rest.
+const lzipFirstMember = 30000
+
+func TestLzipReader(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lz, err := readTestFile("words.lz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		data []byte
+		want []byte
+	}{
+		{lz, words},
+		// concatenated files
+		{append(append([]byte(nil), lz...), lz...), append(append([]byte(nil), words...), words...)},
+		// trailing data is ignored
+		{append(append([]byte(nil), lz...), "trailing data"...), words},
+	} {
+		r, err := xz.NewLzipReader(bytes.NewReader(test.data), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, test.want) {
+			t.Fatal("returned different data")
+		}
+	}
+}
+
+func TestLzipReaderByteReads(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lz, err := readTestFile("words.lz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewLzipReader(iotest.OneByteReader(bytes.NewReader(lz)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, words) {
+		t.Fatal("returned different data")
+	}
+}
+
+func TestLzipReaderMultistream(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lz, err := readTestFile("words.lz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewLzipReader(bytes.NewReader(lz), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members [][]byte
+	for {
+		r.Multistream(false)
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, b)
+		if err = r.Reset(nil); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(members) != 2 ||
+		!bytes.Equal(members[0], words[:lzipFirstMember]) ||
+		!bytes.Equal(members[1], words[lzipFirstMember:]) {
+		t.Fatal("returned different members")
+	}
+}
+
+func TestLzipReaderErrors(t *testing.T) {
+	lz, err := readTestFile("words.lz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// set returns a copy of lz with the byte at i set to v.
+	set := func(i int, v byte) []byte {
+		b := append([]byte(nil), lz...)
+		b[i] = v
+		return b
+	}
+	// corrupt returns a copy of lz with the byte at i changed.
+	corrupt := func(i int) []byte {
+		return set(i, lz[i]^0x01)
+	}
+	for i, test := range []struct {
+		data    []byte
+		dictMax uint32
+		err     error
+	}{
+		{lz[:3], 0, xz.ErrBuf},
+		{corrupt(0), 0, xz.ErrFormat},
+		{corrupt(4), 0, xz.ErrOptions},
+		// dictionary sizes of 2^11 and 2^30 bytes
+		{set(5, 0x0b), 0, xz.ErrData},
+		{set(5, 0x1e), 0, xz.ErrData},
+		{lz, 1 << 15, xz.ErrMemlimit},
+		{lz[:len(lz)-1], 0, xz.ErrBuf},
+		{lz[:len(lz)/2], 0, xz.ErrBuf},
+		// CRC32, data size and member size of the last member
+		{corrupt(len(lz) - 20), 0, xz.ErrData},
+		{corrupt(len(lz) - 16), 0, xz.ErrData},
+		{corrupt(len(lz) - 8), 0, xz.ErrData},
+	} {
+		r, err := xz.NewLzipReader(bytes.NewReader(test.data), test.dictMax)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if err != test.err {
+			t.Fatalf("test %d: wanted error: %v, got: %v\n", i, test.err, err)
+		}
+	}
+}
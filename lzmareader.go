@@ -0,0 +1,245 @@
+/*
+ * Package xz Go LZMAReader API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import "io"
+
+// lzmaFormat is the type of data read by an LZMAReader.
+type lzmaFormat int
+
+const (
+	formatAlone lzmaFormat = iota // .lzma file
+	formatLZMA1                   // raw LZMA1 stream
+	formatLZMA2                   // raw LZMA2 stream
+)
+
+// An LZMAReader is an io.Reader that can be used to retrieve
+// uncompressed data from a legacy .lzma file, the format created by
+// LZMA Utils and by XZ Utils with "xz --format=lzma". Such files are
+// also known as LZMA_Alone files. An LZMAReader can also read raw
+// LZMA1 and LZMA2 streams, which have no container at all, as
+// embedded in other file formats.
+//
+// The data read by an LZMAReader has no integrity check, so corrupt
+// data is not always detected.
+type LZMAReader struct {
+	r        io.Reader       // the wrapped io.Reader
+	format   lzmaFormat      // the type of data read
+	props    byte            // LZMA1 properties of a raw stream
+	dictSize uint32          // dictionary size of a raw stream
+	size     vliType         // uncompressed size of a raw LZMA1 stream
+	rEOF     bool            // true after io.EOF received on r
+	dEOF     bool            // true after decoder has completed
+	in       [inBufSize]byte // backing array for buf.in
+	buf      *xzBuf          // decoder input/output buffers
+	dec      *xzDecLZMA2     // decoder state
+	err      error           // the result of the last decoder call
+}
+
+// NewLZMAReader creates a new LZMAReader reading from r. The
+// decompressor will use an LZMA2 dictionary size up to dictMax bytes
+// in size. Passing a value of zero sets dictMax to DefaultDictMax. If
+// the file requires a dictionary size greater than dictMax in order
+// to decompress, NewLZMAReader returns ErrMemlimit.
+//
+// The 13 byte .lzma header is read from r by NewLZMAReader. If it is
+// not valid ErrFormat is returned.
+//
+// Due to internal buffering, the LZMAReader may read more data than
+// necessary from r.
+func NewLZMAReader(r io.Reader, dictMax uint32) (*LZMAReader, error) {
+	if dictMax == 0 {
+		dictMax = DefaultDictMax
+	}
+	z := &LZMAReader{
+		buf: &xzBuf{},
+		dec: xzDecLZMA2Create(dictMax),
+	}
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// NewLZMA1Reader creates a new LZMAReader reading a raw LZMA1 stream
+// from r. The stream's properties byte (encoding lc, lp and pb) and
+// dictionary size must be supplied, as must its uncompressed size if
+// known. Passing a negative value for size indicates the stream ends
+// with an end of payload marker. No header is read from r.
+//
+// If props is not valid ErrOptions is returned. If dictSize is
+// greater than DefaultDictMax ErrMemlimit is returned.
+func NewLZMA1Reader(r io.Reader, props byte, dictSize uint32, size int64) (*LZMAReader, error) {
+	if dictSize > DefaultDictMax {
+		return nil, ErrMemlimit
+	}
+	if dictSize < lzmaDictMin {
+		dictSize = lzmaDictMin
+	}
+	z := &LZMAReader{
+		format:   formatLZMA1,
+		props:    props,
+		dictSize: dictSize,
+		size:     vliUnknown,
+		buf:      &xzBuf{},
+		dec:      xzDecLZMA2Create(dictSize),
+	}
+	if size >= 0 {
+		z.size = vliType(size)
+	}
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// NewLZMA2Reader creates a new LZMAReader reading a raw LZMA2 stream
+// from r. The stream must begin with a dictionary reset and end with
+// an end marker, as do those in XZ files. The dictionary size used by
+// the stream must be supplied; unlike XZ files it need not be of the
+// form 2^n or 2^n + 2^(n-1). If dictSize is greater than
+// DefaultDictMax ErrMemlimit is returned.
+func NewLZMA2Reader(r io.Reader, dictSize uint32) (*LZMAReader, error) {
+	if dictSize > DefaultDictMax {
+		return nil, ErrMemlimit
+	}
+	if dictSize < lzmaDictMin {
+		dictSize = lzmaDictMin
+	}
+	z := &LZMAReader{
+		format:   formatLZMA2,
+		dictSize: dictSize,
+		buf:      &xzBuf{},
+		dec:      xzDecLZMA2Create(dictSize),
+	}
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// decode is a wrapper around xzDecLZMA1Run and xzDecLZMA2Run which
+// tells the LZMA1 decoder when the input has ended. Like xzDecRun, it
+// returns xzBufError if no progress is possible.
+func (z *LZMAReader) decode() (ret xzRet) {
+	inStart := z.buf.inPos
+	outStart := z.buf.outPos
+	if z.format == formatLZMA2 {
+		ret = xzDecLZMA2Run(z.dec, z.buf)
+	} else {
+		if z.rEOF && z.buf.inPos == len(z.buf.in) {
+			xzDecLZMA1InputEnd(z.dec)
+		}
+		ret = xzDecLZMA1Run(z.dec, z.buf)
+	}
+	if ret == xzOK && z.rEOF &&
+		inStart == z.buf.inPos && outStart == z.buf.outPos {
+		ret = xzBufError
+	}
+	return ret
+}
+
+func (z *LZMAReader) Read(p []byte) (n int, err error) {
+	// restore err
+	err = z.err
+	// set decoder output buffer to p
+	z.buf.out = p
+	z.buf.outPos = 0
+	for {
+		// update n
+		n = z.buf.outPos
+		// if last call to decoder ended with an error, return that error
+		if err != nil {
+			break
+		}
+		// if decoder has finished, return with err == io.EOF
+		if z.dEOF {
+			err = io.EOF
+			break
+		}
+		// if p full, return with err == nil
+		if n == len(p) {
+			break
+		}
+		// if needed, read more data from z.r
+		if z.buf.inPos == len(z.buf.in) && !z.rEOF {
+			rn, e := z.r.Read(z.in[:])
+			if e != nil && e != io.EOF {
+				// read error
+				err = e
+				break
+			}
+			if e == io.EOF {
+				z.rEOF = true
+			}
+			// set new input buffer in z.buf
+			z.buf.in = z.in[:rn]
+			z.buf.inPos = 0
+		}
+		// decode more data
+		switch ret := z.decode(); ret {
+		case xzOK:
+			// no action needed
+		case xzStreamEnd:
+			z.dEOF = true
+		default:
+			err = retError(ret)
+		}
+		// save err
+		z.err = err
+	}
+	return
+}
+
+// Reset discards the LZMAReader z's state and makes it equivalent to
+// the result of its original state from NewLZMAReader, NewLZMA1Reader
+// or NewLZMA2Reader, but reading from r instead. This permits reusing
+// an LZMAReader rather than allocating a new one. For .lzma files the
+// header is read from r again; raw streams are decoded using the
+// same parameters as before.
+func (z *LZMAReader) Reset(r io.Reader) error {
+	z.r = r
+	z.rEOF = false
+	z.dEOF = false
+	z.buf.in = nil
+	z.buf.inPos = 0
+	z.err = nil
+	switch z.format {
+	case formatLZMA1:
+		if xzDecLZMA1Reset(z.dec, z.props, z.dictSize, z.size) != xzOK {
+			z.err = ErrOptions
+		}
+		return z.err
+	case formatLZMA2:
+		xzDecLZMA2ResetDict(z.dec, z.dictSize)
+		return nil
+	}
+	var hdr [lzmaHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrFormat
+		}
+		z.err = err
+		return err
+	}
+	// the header holds the LZMA properties (lc, lp and pb), the
+	// dictionary size and the uncompressed size, which has all bits
+	// set if it is unknown
+	uncompressed := vliType(getLE32(hdr[5:])) |
+		vliType(getLE32(hdr[9:]))<<32
+	switch xzDecLZMA1Reset(z.dec, hdr[0], getLE32(hdr[1:]), uncompressed) {
+	case xzOK:
+	case xzMemlimitError:
+		z.err = ErrMemlimit
+	default:
+		z.err = ErrFormat
+	}
+	return z.err
+}
@@ -0,0 +1,252 @@
+/*
+ * Package xz LZMAReader tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/xi2/xz"
+)
+
+// readLZMA decompresses the .lzma file held in data.
+func readLZMA(data []byte, dictMax uint32) ([]byte, error) {
+	r, err := xz.NewLZMAReader(bytes.NewReader(data), dictMax)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// setLZMASize returns a copy of the .lzma file held in data with the
+// uncompressed size in its header set to size.
+func setLZMASize(data []byte, size uint64) []byte {
+	data = append([]byte(nil), data...)
+	for i := 0; i < 8; i++ {
+		data[5+i] = byte(size >> uint(8*i))
+	}
+	return data
+}
+
+func TestLZMAReader(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lzma, err := readTestFile("words.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known, err := readTestFile("words-20k-known_size.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		data []byte
+		want []byte
+	}{
+		// end of payload marker only
+		{lzma, words},
+		// uncompressed size only
+		{known, words[:20000]},
+		// both uncompressed size and end of payload marker
+		{setLZMASize(lzma, uint64(len(words))), words},
+	} {
+		b, err := readLZMA(test.data, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, test.want) {
+			t.Fatalf("returned different data\n")
+		}
+	}
+}
+
+func TestLZMAReaderByteReads(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lzma, err := readTestFile("words.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewLZMAReader(
+		iotest.OneByteReader(bytes.NewReader(lzma)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, words) {
+		t.Fatal("returned different data")
+	}
+}
+
+func TestLZMAReaderErrors(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lzma, err := readTestFile("words.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known, err := readTestFile("words-20k-known_size.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badProps := append([]byte{0xff}, lzma[1:]...)
+	for i, test := range []struct {
+		data    []byte
+		dictMax uint32
+		err     error
+	}{
+		{lzma[:10], 0, xz.ErrFormat},
+		{badProps, 0, xz.ErrFormat},
+		{lzma, 1 << 20, xz.ErrMemlimit},
+		{lzma[:len(lzma)-1], 0, xz.ErrBuf},
+		{lzma[:len(lzma)/2], 0, xz.ErrBuf},
+		{known[:len(known)-1], 0, xz.ErrBuf},
+		{setLZMASize(lzma, uint64(len(words)-1)), 0, xz.ErrData},
+		{setLZMASize(lzma, uint64(len(words)+1)), 0, xz.ErrData},
+		{setLZMASize(known, 19999), 0, xz.ErrData},
+		{setLZMASize(known, 20001), 0, xz.ErrBuf},
+	} {
+		if _, err = readLZMA(test.data, test.dictMax); err != test.err {
+			t.Fatalf("test %d: wanted error: %v, got: %v\n", i, test.err, err)
+		}
+	}
+}
+
+func TestLZMAReaderReset(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lzma, err := readTestFile("words.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewLZMAReader(bytes.NewReader(lzma), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, words) {
+			t.Fatal("returned different data")
+		}
+		if err = r.Reset(bytes.NewReader(lzma)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLZMA1Reader(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lzma, err := readTestFile("words.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known, err := readTestFile("words-20k-known_size.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		data []byte
+		size int64
+		want []byte
+	}{
+		{lzma, -1, words},
+		{known, 20000, words[:20000]},
+	} {
+		// the raw stream follows the 13 byte .lzma header
+		dictSize := uint32(test.data[1]) | uint32(test.data[2])<<8 |
+			uint32(test.data[3])<<16 | uint32(test.data[4])<<24
+		r, err := xz.NewLZMA1Reader(bytes.NewReader(test.data[13:]),
+			test.data[0], dictSize, test.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, test.want) {
+			t.Fatal("returned different data")
+		}
+	}
+	if _, err = xz.NewLZMA1Reader(bytes.NewReader(nil), 225, 0, -1); err != xz.ErrOptions {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrOptions, err)
+	}
+	if _, err = xz.NewLZMA1Reader(bytes.NewReader(nil), 93,
+		xz.DefaultDictMax+1, -1); err != xz.ErrMemlimit {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
+	}
+}
+
+// rawLZMA2 returns the LZMA2 stream held in the first block of the
+// named XZ file.
+func rawLZMA2(t *testing.T, file string) []byte {
+	data, err := readTestFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := xz.ReadIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := idx.Streams[0]
+	blk := st.Blocks[0]
+	block := data[blk.Offset : blk.Offset+blk.UnpaddedSize]
+	// a block header without sizes, holding only the LZMA2 filter
+	if block[1] != 0x00 || block[2] != 0x21 {
+		t.Fatalf("%s: unexpected block header\n", file)
+	}
+	checkSize := map[xz.CheckID]int{
+		xz.CheckNone: 0, xz.CheckCRC32: 4, xz.CheckCRC64: 8, xz.CheckSHA256: 32,
+	}[st.CheckType]
+	return block[(int(block[0])+1)*4 : len(block)-checkSize]
+}
+
+func TestLZMA2Reader(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	raw := rawLZMA2(t, "words.xz")
+	// the dictionary size need only cover the uncompressed data
+	r, err := xz.NewLZMA2Reader(bytes.NewReader(raw), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		b, err := ioutil.ReadAll(iotest.OneByteReader(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, words) {
+			t.Fatal("returned different data")
+		}
+		if err = r.Reset(bytes.NewReader(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = r.Reset(bytes.NewReader(raw[:len(raw)-1])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(r); err != xz.ErrBuf {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrBuf, err)
+	}
+	r, err = xz.NewLZMA2Reader(bytes.NewReader(raw), 1<<12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(r); err != xz.ErrData {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrData, err)
+	}
+	if _, err = xz.NewLZMA2Reader(bytes.NewReader(raw),
+		xz.DefaultDictMax+1); err != xz.ErrMemlimit {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
+	}
+}
@@ -0,0 +1,799 @@
+/*
+ * Hash chain and binary tree match finders
+ *
+ * Authors: Lasse Collin <lasse.collin@tukaani.org>
+ *          Igor Pavlov <http://7-zip.org/>
+ *
+ * Translation to Go: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+// Package matchfinder implements the match finders of liblzma, the
+// library of XZ Utils, which find repeated byte sequences in a sliding
+// window as needed by an LZMA encoder.
+//
+// Five kinds of match finder are provided. The hash chain match
+// finders HC3 and HC4 are fast but may miss matches. The binary tree
+// match finders BT2, BT3 and BT4 are slower but find longer matches,
+// giving better compression. The digit is the number of bytes hashed
+// to locate candidate matches, which is also the shortest match found
+// by searching, although HC4, BT3 and BT4 also report shorter matches
+// found using smaller hash tables.
+//
+// The window keeps the dictSize bytes before the current position,
+// the same amount of history as the decoder's dictionary of that
+// size, so every match found can be decoded by an LZMA or LZMA2
+// decoder using a dictionary of dictSize bytes.
+package matchfinder
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+// A Kind is a kind of match finder.
+type Kind int
+
+// The kinds of match finder, named as in XZ Utils.
+const (
+	HC3 Kind = iota // hash chain using 2 and 3 byte hashes
+	HC4             // hash chain using 2, 3 and 4 byte hashes
+	BT2             // binary tree using 2 byte hashes
+	BT3             // binary tree using 2 and 3 byte hashes
+	BT4             // binary tree using 2, 3 and 4 byte hashes
+)
+
+func (k Kind) String() string {
+	switch k {
+	case HC3:
+		return "HC3"
+	case HC4:
+		return "HC4"
+	case BT2:
+		return "BT2"
+	case BT3:
+		return "BT3"
+	case BT4:
+		return "BT4"
+	}
+	return "Unknown"
+}
+
+const (
+	// MinDictSize is the smallest dictionary size allowed, the same
+	// as the smallest LZMA2 dictionary.
+	MinDictSize = 1 << 12
+	// MaxDictSize is the largest dictionary size allowed, the same
+	// as for liblzma.
+	MaxDictSize = 1<<30 + 1<<29
+	// MaxLen is the length of the longest match an LZMA encoder can
+	// use.
+	MaxLen = 273
+)
+
+var (
+	errKind     = errors.New("matchfinder: invalid kind")
+	errDictSize = errors.New("matchfinder: invalid dictionary size")
+	errNiceLen  = errors.New("matchfinder: invalid nice length")
+)
+
+// A Match is a match found at the current position.
+type Match struct {
+	Len  uint32 // length of the match
+	Dist uint32 // distance back to the match minus one, as in LZMA
+}
+
+/* from liblzma/lz/lz_encoder.h ***************************************/
+
+/*
+ * Number of bytes that must be available after the current position
+ * before matches may be searched for without being told that there
+ * is no more input coming.
+ */
+const keepAfter = MaxLen + 1
+
+/* Sizes of the hash tables used to find two and three byte matches */
+const (
+	hash2Size = 1 << 10
+	hash3Size = 1 << 16
+)
+
+// A MatchFinder finds matches in data passed to it through Fill.
+//
+// The data is processed one position at a time by Find, which
+// reports the matches at the current position, or Skip, which only
+// adds positions to the search structures. Either may only be used
+// while Avail returns a positive value.
+type MatchFinder struct {
+	kind Kind
+	/*
+	 * The history buffer followed by input which has not yet been
+	 * processed. Only buf[:writePos] holds valid data.
+	 */
+	buf []byte
+	/* Position in buf of the next byte to process */
+	readPos int
+	/* Positions at or beyond readLimit may not be processed */
+	readLimit int
+	/* Position in buf where new input is copied to */
+	writePos int
+	/* Number of bytes before readPos kept when the window is moved */
+	keepBefore int
+	/* Number of bytes moved out of the window since the last Reset */
+	moved int64
+	/* True once Finish has been called */
+	finishing bool
+	/*
+	 * Offset added to readPos to get the position values that are
+	 * stored in the hash tables. Position values smaller than
+	 * cyclicSize are never stored, so a value of zero in the hash
+	 * tables means "no match".
+	 */
+	offset uint32
+	/* Matches longer than niceLen are not searched for */
+	niceLen uint32
+	/* Maximum number of hash chain or tree nodes to visit */
+	depth uint32
+	/* Number of bytes hashed to find candidate matches */
+	hashBytes uint32
+	/* Current position in son */
+	cyclicPos uint32
+	/* Number of positions held in son; the dictionary size plus one */
+	cyclicSize uint32
+	/*
+	 * Hash tables for two and three byte hashes, used by the match
+	 * finders hashing more bytes than that, and the main hash table
+	 * of hashBytes byte hashes.
+	 */
+	hash2    []uint32
+	hash3    []uint32
+	hash     []uint32
+	hashMask uint32
+	/*
+	 * Hash chains, with one entry per position, or binary trees,
+	 * with a pair of entries per position.
+	 */
+	son []uint32
+	/* Functions implementing the kind of match finder */
+	find func(mf *MatchFinder, matches []Match) []Match
+	skip func(mf *MatchFinder)
+}
+
+/* from liblzma/lz/lz_encoder.c ***************************************/
+
+// New returns a new MatchFinder of the given kind using a dictionary
+// of dictSize bytes. Matches of niceLen bytes or more end the search
+// for longer ones, and at most depth candidate matches are examined
+// at each position. A depth of zero selects the default of XZ Utils
+// for the kind.
+//
+// dictSize must be between MinDictSize and MaxDictSize, and niceLen
+// must be at least the number of bytes hashed and at most MaxLen.
+func New(kind Kind, dictSize, niceLen, depth uint32) (*MatchFinder, error) {
+	mf := &MatchFinder{kind: kind}
+	switch kind {
+	case HC3:
+		mf.hashBytes = 3
+		mf.find, mf.skip = hc3Find, hc3Skip
+	case HC4:
+		mf.hashBytes = 4
+		mf.find, mf.skip = hc4Find, hc4Skip
+	case BT2:
+		mf.hashBytes = 2
+		mf.find, mf.skip = bt2Find, bt2Skip
+	case BT3:
+		mf.hashBytes = 3
+		mf.find, mf.skip = bt3Find, bt3Skip
+	case BT4:
+		mf.hashBytes = 4
+		mf.find, mf.skip = bt4Find, bt4Skip
+	default:
+		return nil, errKind
+	}
+	if dictSize < MinDictSize || dictSize > MaxDictSize {
+		return nil, errDictSize
+	}
+	if niceLen < mf.hashBytes || niceLen > MaxLen {
+		return nil, errNiceLen
+	}
+	/*
+	 * Reserve space so that the window doesn't need to be moved
+	 * too often.
+	 */
+	mf.keepBefore = int(dictSize)
+	reserve := int(dictSize/2) + 1<<19
+	mf.buf = make([]byte, mf.keepBefore+reserve+keepAfter)
+	mf.cyclicSize = dictSize + 1
+	if kind >= BT2 {
+		mf.son = make([]uint32, 2*mf.cyclicSize)
+	} else {
+		mf.son = make([]uint32, mf.cyclicSize)
+	}
+	/*
+	 * Size the main hash table according to the dictionary size.
+	 * Its size is always a power of two.
+	 */
+	var hs uint32
+	if mf.hashBytes == 2 {
+		hs = 0xffff
+	} else {
+		hs = dictSize - 1
+		hs |= hs >> 1
+		hs |= hs >> 2
+		hs |= hs >> 4
+		hs |= hs >> 8
+		hs |= hs >> 16
+		hs >>= 1
+		hs |= 0xffff
+		if hs > 1<<24 {
+			if mf.hashBytes == 3 {
+				hs = 1<<24 - 1
+			} else {
+				hs >>= 1
+			}
+		}
+	}
+	mf.hashMask = hs
+	mf.hash = make([]uint32, hs+1)
+	if mf.hashBytes > 2 {
+		mf.hash2 = make([]uint32, hash2Size)
+	}
+	if mf.hashBytes > 3 {
+		mf.hash3 = make([]uint32, hash3Size)
+	}
+	mf.niceLen = niceLen
+	if depth == 0 {
+		if kind >= BT2 {
+			depth = 16 + niceLen/2
+		} else {
+			depth = 4 + niceLen/4
+		}
+	}
+	mf.depth = depth
+	mf.Reset()
+	return mf, nil
+}
+
+// Kind returns the kind of the match finder.
+func (mf *MatchFinder) Kind() Kind {
+	return mf.kind
+}
+
+// Reset discards the data in the window, making mf ready to find
+// matches in new data.
+func (mf *MatchFinder) Reset() {
+	mf.readPos = 0
+	mf.readLimit = 0
+	mf.writePos = 0
+	mf.moved = 0
+	mf.finishing = false
+	mf.offset = mf.cyclicSize
+	mf.cyclicPos = 0
+	for _, h := range [][]uint32{mf.hash2, mf.hash3, mf.hash} {
+		for i := range h {
+			h[i] = 0
+		}
+	}
+	/*
+	 * There is no need to clear son as entries are always written
+	 * before they can be read.
+	 */
+}
+
+/*
+ * Move the data in the window towards the beginning of buf, keeping
+ * at least keepBefore bytes before the current position.
+ */
+func moveWindow(mf *MatchFinder) {
+	moveOffset := mf.readPos - mf.keepBefore
+	if moveOffset <= 0 {
+		return
+	}
+	copy(mf.buf, mf.buf[moveOffset:mf.writePos])
+	mf.moved += int64(moveOffset)
+	mf.offset += uint32(moveOffset)
+	mf.readPos -= moveOffset
+	mf.readLimit -= moveOffset
+	mf.writePos -= moveOffset
+}
+
+/* Update readLimit after more input has arrived or finishing was set. */
+func setLimit(mf *MatchFinder) {
+	if mf.finishing {
+		mf.readLimit = mf.writePos
+	} else {
+		mf.readLimit = mf.writePos - keepAfter
+		if mf.readLimit < mf.readPos {
+			mf.readLimit = mf.readPos
+		}
+	}
+}
+
+// Fill copies as much of p as will fit into the window and returns
+// the number of bytes copied. Fewer than len(p) bytes are copied when
+// the window is full, in which case the positions available should
+// be processed before Fill is called again.
+func (mf *MatchFinder) Fill(p []byte) int {
+	if mf.finishing {
+		return 0
+	}
+	if mf.writePos == len(mf.buf) {
+		moveWindow(mf)
+	}
+	n := copy(mf.buf[mf.writePos:], p)
+	mf.writePos += n
+	setLimit(mf)
+	return n
+}
+
+// Finish tells mf that no more data will be passed to Fill, so that
+// the positions near the end of the data may be processed.
+func (mf *MatchFinder) Finish() {
+	mf.finishing = true
+	setLimit(mf)
+}
+
+// Avail returns the number of positions which may be processed by
+// Find and Skip before more data must be passed to Fill. Unless
+// Finish has been called, the last MaxLen+1 bytes passed to Fill are
+// not available, as matches starting before them may extend into
+// data not yet seen.
+func (mf *MatchFinder) Avail() int {
+	return mf.readLimit - mf.readPos
+}
+
+// Pos returns the current position, which is the number of bytes
+// processed by Find and Skip since mf was last reset.
+func (mf *MatchFinder) Pos() int64 {
+	return mf.moved + int64(mf.readPos)
+}
+
+// Window returns the data in the window. The current position is at
+// offset len(window)-n, where n is the number of bytes passed to Fill
+// but not yet processed. The returned slice is only valid until the
+// next call to a method of mf.
+func (mf *MatchFinder) Window() []byte {
+	return mf.buf[:mf.writePos]
+}
+
+// Find appends the matches found at the current position to matches,
+// in order of increasing length, and advances to the next position.
+// Returns the extended slice. Each match appended is longer than the
+// previous one, and no match extends beyond the data passed to Fill.
+//
+// A match reaching niceLen bytes ends the search, so longer matches
+// are not searched for. The length of such a match is extended as far
+// as possible, up to MaxLen.
+func (mf *MatchFinder) Find(matches []Match) []Match {
+	avail := uint32(mf.writePos - mf.readPos)
+	start := len(matches)
+	matches = mf.find(mf, matches)
+	if n := len(matches); n > start && matches[n-1].Len == mf.niceLen {
+		limit := avail
+		if limit > MaxLen {
+			limit = MaxLen
+		}
+		p1 := mf.readPos - 1
+		p2 := p1 - int(matches[n-1].Dist) - 1
+		matches[n-1].Len = memcmplen(mf.buf, p1, p2, mf.niceLen, limit)
+	}
+	return matches
+}
+
+// Skip advances n positions, adding them to the search structures
+// without looking for matches. n must not be more than Avail returns.
+func (mf *MatchFinder) Skip(n int) {
+	for ; n > 0; n-- {
+		mf.skip(mf)
+	}
+}
+
+/*
+ * Return the number of bytes which are equal in buf[a:] and buf[b:]
+ * given that the first n bytes are already known to be equal. At most
+ * limit bytes are compared.
+ */
+func memcmplen(buf []byte, a int, b int, n uint32, limit uint32) uint32 {
+	for n < limit && buf[a+int(n)] == buf[b+int(n)] {
+		n++
+	}
+	return n
+}
+
+/*
+ * When the position values are about to overflow, subtract a constant
+ * from all of them. Values that would become too small are set to
+ * zero which means "no match".
+ */
+func normalize(mf *MatchFinder) {
+	subValue := ^uint32(0) - mf.cyclicSize
+	for _, h := range [][]uint32{mf.hash2, mf.hash3, mf.hash, mf.son} {
+		for i := range h {
+			if h[i] <= subValue {
+				h[i] = 0
+			} else {
+				h[i] -= subValue
+			}
+		}
+	}
+	mf.offset -= subValue
+}
+
+/* Advance the match finder by one byte. */
+func movePos(mf *MatchFinder) {
+	mf.cyclicPos++
+	if mf.cyclicPos == mf.cyclicSize {
+		mf.cyclicPos = 0
+	}
+	mf.readPos++
+	if uint32(mf.readPos)+mf.offset == ^uint32(0) {
+		normalize(mf)
+	}
+}
+
+/* from liblzma/lz/lz_encoder_mf.c ************************************/
+
+/*
+ * Return the limit on the length of matches at the current position,
+ * or zero if there is too little input left to hash. This only
+ * happens at the end of the data, in which case the position is
+ * passed over.
+ */
+func findLimit(mf *MatchFinder) uint32 {
+	limit := uint32(mf.writePos - mf.readPos)
+	if limit >= mf.niceLen {
+		return mf.niceLen
+	}
+	if limit < mf.hashBytes {
+		movePos(mf)
+		return 0
+	}
+	return limit
+}
+
+/*
+ * Calculate the hash values of the bytes at buf[cur:]. The two and
+ * three byte hashes have the useful property that, given equal first
+ * bytes, equal hashes imply equal second (and third) bytes. h3 uses
+ * the mask of the main hash table if three bytes are hashed.
+ */
+func hash3Calc(mf *MatchFinder, cur int) (h2, h3 uint32) {
+	b := mf.buf[cur : cur+3]
+	temp := crc32.IEEETable[b[0]] ^ uint32(b[1])
+	h2 = temp & (hash2Size - 1)
+	h3 = (temp ^ uint32(b[2])<<8) & mf.hashMask
+	return
+}
+
+func hash4Calc(mf *MatchFinder, cur int) (h2, h3, h4 uint32) {
+	b := mf.buf[cur : cur+4]
+	temp := crc32.IEEETable[b[0]] ^ uint32(b[1])
+	h2 = temp & (hash2Size - 1)
+	h3 = (temp ^ uint32(b[2])<<8) & (hash3Size - 1)
+	h4 = (temp ^ uint32(b[2])<<8 ^ crc32.IEEETable[b[3]]<<5) & mf.hashMask
+	return
+}
+
+/*
+ * Follow the hash chain starting at curMatch and append matches
+ * longer than lenBest to matches, which is returned.
+ */
+func hcFind(mf *MatchFinder, lenLimit uint32, pos uint32, cur int,
+	curMatch uint32, matches []Match, lenBest uint32) []Match {
+	depth := mf.depth
+	mf.son[mf.cyclicPos] = curMatch
+	for {
+		delta := pos - curMatch
+		if depth == 0 || delta >= mf.cyclicSize {
+			return matches
+		}
+		depth--
+		pb := cur - int(delta)
+		if delta > mf.cyclicPos {
+			curMatch = mf.son[mf.cyclicPos-delta+mf.cyclicSize]
+		} else {
+			curMatch = mf.son[mf.cyclicPos-delta]
+		}
+		if mf.buf[pb+int(lenBest)] == mf.buf[cur+int(lenBest)] &&
+			mf.buf[pb] == mf.buf[cur] {
+			l := memcmplen(mf.buf, pb, cur, 1, lenLimit)
+			if lenBest < l {
+				lenBest = l
+				matches = append(matches, Match{Len: l, Dist: delta - 1})
+				if l == lenLimit {
+					return matches
+				}
+			}
+		}
+	}
+}
+
+/*
+ * Search the binary tree rooted at curMatch, appending matches longer
+ * than lenBest to matches, which is returned. The tree is rebuilt
+ * with the current position as its root. If find is false no matches
+ * are appended; this is used when skipping.
+ */
+func btFind(mf *MatchFinder, lenLimit uint32, pos uint32, cur int,
+	curMatch uint32, matches []Match, lenBest uint32, find bool) []Match {
+	depth := mf.depth
+	son := mf.son
+	ptr0 := mf.cyclicPos<<1 + 1
+	ptr1 := mf.cyclicPos << 1
+	var len0, len1 uint32
+	for {
+		delta := pos - curMatch
+		if depth == 0 || delta >= mf.cyclicSize {
+			son[ptr0] = 0
+			son[ptr1] = 0
+			return matches
+		}
+		depth--
+		var pair uint32
+		if delta > mf.cyclicPos {
+			pair = (mf.cyclicPos - delta + mf.cyclicSize) << 1
+		} else {
+			pair = (mf.cyclicPos - delta) << 1
+		}
+		pb := cur - int(delta)
+		l := len0
+		if len1 < l {
+			l = len1
+		}
+		if mf.buf[pb+int(l)] == mf.buf[cur+int(l)] {
+			l = memcmplen(mf.buf, pb, cur, l+1, lenLimit)
+			if lenBest < l {
+				lenBest = l
+				if find {
+					matches = append(matches,
+						Match{Len: l, Dist: delta - 1})
+				}
+				if l == lenLimit {
+					son[ptr1] = son[pair]
+					son[ptr0] = son[pair+1]
+					return matches
+				}
+			}
+		}
+		if mf.buf[pb+int(l)] < mf.buf[cur+int(l)] {
+			son[ptr1] = curMatch
+			ptr1 = pair + 1
+			curMatch = son[ptr1]
+			len1 = l
+		} else {
+			son[ptr0] = curMatch
+			ptr0 = pair
+			curMatch = son[ptr0]
+			len0 = l
+		}
+	}
+}
+
+func hc3Find(mf *MatchFinder, matches []Match) []Match {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return matches
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	h2, h3 := hash3Calc(mf, cur)
+	delta2 := pos - mf.hash2[h2]
+	curMatch := mf.hash[h3]
+	mf.hash2[h2] = pos
+	mf.hash[h3] = pos
+	lenBest := uint32(2)
+	if delta2 < mf.cyclicSize && mf.buf[cur-int(delta2)] == mf.buf[cur] {
+		lenBest = memcmplen(mf.buf, cur-int(delta2), cur, lenBest, lenLimit)
+		matches = append(matches, Match{Len: lenBest, Dist: delta2 - 1})
+		if lenBest == lenLimit {
+			mf.son[mf.cyclicPos] = curMatch
+			movePos(mf)
+			return matches
+		}
+	}
+	matches = hcFind(mf, lenLimit, pos, cur, curMatch, matches, lenBest)
+	movePos(mf)
+	return matches
+}
+
+func hc3Skip(mf *MatchFinder) {
+	if findLimit(mf) == 0 {
+		return
+	}
+	pos := uint32(mf.readPos) + mf.offset
+	h2, h3 := hash3Calc(mf, mf.readPos)
+	curMatch := mf.hash[h3]
+	mf.hash2[h2] = pos
+	mf.hash[h3] = pos
+	mf.son[mf.cyclicPos] = curMatch
+	movePos(mf)
+}
+
+func hc4Find(mf *MatchFinder, matches []Match) []Match {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return matches
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	start := len(matches)
+	h2, h3, h4 := hash4Calc(mf, cur)
+	delta2 := pos - mf.hash2[h2]
+	delta3 := pos - mf.hash3[h3]
+	curMatch := mf.hash[h4]
+	mf.hash2[h2] = pos
+	mf.hash3[h3] = pos
+	mf.hash[h4] = pos
+	lenBest := uint32(1)
+	if delta2 < mf.cyclicSize && mf.buf[cur-int(delta2)] == mf.buf[cur] {
+		lenBest = 2
+		matches = append(matches, Match{Len: 2, Dist: delta2 - 1})
+	}
+	if delta2 != delta3 && delta3 < mf.cyclicSize &&
+		mf.buf[cur-int(delta3)] == mf.buf[cur] {
+		lenBest = 3
+		matches = append(matches, Match{Dist: delta3 - 1})
+		delta2 = delta3
+	}
+	if n := len(matches); n > start {
+		lenBest = memcmplen(mf.buf, cur-int(delta2), cur, lenBest, lenLimit)
+		matches[n-1].Len = lenBest
+		if lenBest == lenLimit {
+			mf.son[mf.cyclicPos] = curMatch
+			movePos(mf)
+			return matches
+		}
+	}
+	if lenBest < 3 {
+		lenBest = 3
+	}
+	matches = hcFind(mf, lenLimit, pos, cur, curMatch, matches, lenBest)
+	movePos(mf)
+	return matches
+}
+
+func hc4Skip(mf *MatchFinder) {
+	if findLimit(mf) == 0 {
+		return
+	}
+	pos := uint32(mf.readPos) + mf.offset
+	h2, h3, h4 := hash4Calc(mf, mf.readPos)
+	curMatch := mf.hash[h4]
+	mf.hash2[h2] = pos
+	mf.hash3[h3] = pos
+	mf.hash[h4] = pos
+	mf.son[mf.cyclicPos] = curMatch
+	movePos(mf)
+}
+
+func bt2Find(mf *MatchFinder, matches []Match) []Match {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return matches
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	h := uint32(mf.buf[cur]) | uint32(mf.buf[cur+1])<<8
+	curMatch := mf.hash[h]
+	mf.hash[h] = pos
+	matches = btFind(mf, lenLimit, pos, cur, curMatch, matches, 1, true)
+	movePos(mf)
+	return matches
+}
+
+func bt2Skip(mf *MatchFinder) {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	h := uint32(mf.buf[cur]) | uint32(mf.buf[cur+1])<<8
+	curMatch := mf.hash[h]
+	mf.hash[h] = pos
+	btFind(mf, lenLimit, pos, cur, curMatch, nil, 1, false)
+	movePos(mf)
+}
+
+func bt3Find(mf *MatchFinder, matches []Match) []Match {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return matches
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	h2, h3 := hash3Calc(mf, cur)
+	delta2 := pos - mf.hash2[h2]
+	curMatch := mf.hash[h3]
+	mf.hash2[h2] = pos
+	mf.hash[h3] = pos
+	lenBest := uint32(2)
+	if delta2 < mf.cyclicSize && mf.buf[cur-int(delta2)] == mf.buf[cur] {
+		lenBest = memcmplen(mf.buf, cur-int(delta2), cur, lenBest, lenLimit)
+		matches = append(matches, Match{Len: lenBest, Dist: delta2 - 1})
+		if lenBest == lenLimit {
+			btFind(mf, lenLimit, pos, cur, curMatch, nil, 0, false)
+			movePos(mf)
+			return matches
+		}
+	}
+	matches = btFind(mf, lenLimit, pos, cur, curMatch, matches, lenBest, true)
+	movePos(mf)
+	return matches
+}
+
+func bt3Skip(mf *MatchFinder) {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	h2, h3 := hash3Calc(mf, cur)
+	curMatch := mf.hash[h3]
+	mf.hash2[h2] = pos
+	mf.hash[h3] = pos
+	btFind(mf, lenLimit, pos, cur, curMatch, nil, 0, false)
+	movePos(mf)
+}
+
+func bt4Find(mf *MatchFinder, matches []Match) []Match {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return matches
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	start := len(matches)
+	h2, h3, h4 := hash4Calc(mf, cur)
+	delta2 := pos - mf.hash2[h2]
+	delta3 := pos - mf.hash3[h3]
+	curMatch := mf.hash[h4]
+	mf.hash2[h2] = pos
+	mf.hash3[h3] = pos
+	mf.hash[h4] = pos
+	lenBest := uint32(1)
+	if delta2 < mf.cyclicSize && mf.buf[cur-int(delta2)] == mf.buf[cur] {
+		lenBest = 2
+		matches = append(matches, Match{Len: 2, Dist: delta2 - 1})
+	}
+	if delta2 != delta3 && delta3 < mf.cyclicSize &&
+		mf.buf[cur-int(delta3)] == mf.buf[cur] {
+		lenBest = 3
+		matches = append(matches, Match{Dist: delta3 - 1})
+		delta2 = delta3
+	}
+	if n := len(matches); n > start {
+		lenBest = memcmplen(mf.buf, cur-int(delta2), cur, lenBest, lenLimit)
+		matches[n-1].Len = lenBest
+		if lenBest == lenLimit {
+			btFind(mf, lenLimit, pos, cur, curMatch, nil, 0, false)
+			movePos(mf)
+			return matches
+		}
+	}
+	if lenBest < 3 {
+		lenBest = 3
+	}
+	matches = btFind(mf, lenLimit, pos, cur, curMatch, matches, lenBest, true)
+	movePos(mf)
+	return matches
+}
+
+func bt4Skip(mf *MatchFinder) {
+	lenLimit := findLimit(mf)
+	if lenLimit == 0 {
+		return
+	}
+	cur := mf.readPos
+	pos := uint32(cur) + mf.offset
+	h2, h3, h4 := hash4Calc(mf, cur)
+	curMatch := mf.hash[h4]
+	mf.hash2[h2] = pos
+	mf.hash3[h3] = pos
+	mf.hash[h4] = pos
+	btFind(mf, lenLimit, pos, cur, curMatch, nil, 0, false)
+	movePos(mf)
+}
@@ -0,0 +1,245 @@
+/*
+ * Match finder tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package matchfinder_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/xi2/xz"
+	"github.com/xi2/xz/matchfinder"
+)
+
+var kinds = []matchfinder.Kind{
+	matchfinder.HC3, matchfinder.HC4,
+	matchfinder.BT2, matchfinder.BT3, matchfinder.BT4,
+}
+
+// minLen returns the number of bytes hashed by the match finder kind.
+func minLen(kind matchfinder.Kind) int {
+	switch kind {
+	case matchfinder.BT2:
+		return 2
+	case matchfinder.HC3, matchfinder.BT3:
+		return 3
+	}
+	return 4
+}
+
+// readWords returns the uncompressed contents of words.xz.
+func readWords(tb testing.TB) []byte {
+	data, err := ioutil.ReadFile(
+		filepath.Join("..", "testdata", "other", "words.xz"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	r, err := xz.NewReader(bytes.NewReader(data), 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if data, err = ioutil.ReadAll(r); err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
+
+// longest returns the length of the longest match at data[pos:] within
+// dictSize bytes, limited to limit bytes.
+func longest(data []byte, pos int, dictSize int, limit int) int {
+	if limit > len(data)-pos {
+		limit = len(data) - pos
+	}
+	best := 0
+	for dist := 1; dist <= dictSize && dist <= pos; dist++ {
+		n := 0
+		for n < limit && data[pos-dist+n] == data[pos+n] {
+			n++
+		}
+		if n > best {
+			best = n
+		}
+	}
+	return best
+}
+
+// checkMatches checks that the matches found at position pos of data
+// are real, within dictSize bytes and in order of increasing length.
+func checkMatches(t *testing.T, kind matchfinder.Kind, data []byte, pos int, dictSize int, matches []matchfinder.Match) {
+	prev := uint32(0)
+	for _, m := range matches {
+		dist := int(m.Dist) + 1
+		if m.Len < 2 || m.Len > matchfinder.MaxLen || m.Len <= prev ||
+			dist > pos || dist > dictSize || pos+int(m.Len) > len(data) ||
+			!bytes.Equal(data[pos-dist:pos-dist+int(m.Len)],
+				data[pos:pos+int(m.Len)]) {
+			t.Fatalf("%v: position %d: bad match %+v in %+v",
+				kind, pos, m, matches)
+		}
+		prev = m.Len
+	}
+}
+
+func TestFindLongest(t *testing.T) {
+	// with an unlimited depth the longest match of at least the
+	// number of bytes hashed is always found
+	data := readWords(t)[:20000]
+	const dictSize = matchfinder.MinDictSize
+	for _, kind := range kinds {
+		mf, err := matchfinder.New(kind, dictSize, matchfinder.MaxLen, 1<<30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := mf.Fill(data); n != len(data) {
+			t.Fatalf("%v: filled %d bytes, wanted %d", kind, n, len(data))
+		}
+		mf.Finish()
+		var matches []matchfinder.Match
+		for pos := 0; mf.Avail() > 0; pos++ {
+			if mf.Pos() != int64(pos) {
+				t.Fatalf("%v: wanted position %d, got %d", kind, pos, mf.Pos())
+			}
+			matches = mf.Find(matches[:0])
+			checkMatches(t, kind, data, pos, dictSize, matches)
+			got := 0
+			if len(matches) > 0 {
+				got = int(matches[len(matches)-1].Len)
+			}
+			want := longest(data, pos, dictSize, matchfinder.MaxLen)
+			if want >= minLen(kind) && got != want {
+				t.Fatalf("%v: position %d: wanted longest match %d, got %d",
+					kind, pos, want, got)
+			}
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, test := range []struct {
+		kind     matchfinder.Kind
+		dictSize uint32
+		niceLen  uint32
+	}{
+		{matchfinder.BT4 + 1, 1 << 16, 64},
+		{matchfinder.HC4, matchfinder.MinDictSize - 1, 64},
+		{matchfinder.BT2, matchfinder.MaxDictSize + 1, 64},
+		{matchfinder.HC4, 1 << 16, 3},
+		{matchfinder.BT2, 1 << 16, matchfinder.MaxLen + 1},
+	} {
+		if _, err := matchfinder.New(test.kind, test.dictSize,
+			test.niceLen, 0); err == nil {
+			t.Fatalf("%+v: wanted error", test)
+		}
+	}
+}
+
+// fuzzFind runs a match finder of the given kind over data passed in
+// pieces of size bytes, finding matches at every position or, if skip
+// is true, skipping the rest of each long match.
+func fuzzFind(t *testing.T, kind matchfinder.Kind, data []byte, size int, niceLen uint32, skip bool) {
+	const dictSize = matchfinder.MinDictSize
+	mf, err := matchfinder.New(kind, dictSize, niceLen, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var matches []matchfinder.Match
+	in := data
+	for pos := 0; ; {
+		if mf.Avail() == 0 {
+			if len(in) == 0 {
+				if mf.Pos() == int64(len(data)) {
+					break
+				}
+				mf.Finish()
+				continue
+			}
+			n := size
+			if n > len(in) {
+				n = len(in)
+			}
+			n = mf.Fill(in[:n])
+			in = in[n:]
+			continue
+		}
+		matches = mf.Find(matches[:0])
+		checkMatches(t, kind, data, pos, dictSize, matches)
+		pos++
+		if n := len(matches); skip && n > 0 && matches[n-1].Len > 2 {
+			k := int(matches[n-1].Len) - 1
+			if k > mf.Avail() {
+				k = mf.Avail()
+			}
+			mf.Skip(k)
+			pos += k
+		}
+		if mf.Pos() != int64(pos) {
+			t.Fatalf("%v: wanted position %d, got %d", kind, pos, mf.Pos())
+		}
+	}
+}
+
+// fuzzLenMax is the most data used by FuzzFind. The fuzzer minimizes
+// each new input it finds, taking time growing with the square of its
+// length, so inputs are kept short. TestFindLongest covers matches
+// limited by the dictionary size, which short inputs never reach.
+const fuzzLenMax = 256
+
+func FuzzFind(f *testing.F) {
+	words := readWords(f)
+	for i := range kinds {
+		f.Add(uint8(i), words[:fuzzLenMax], uint16(1000), uint8(32), false)
+	}
+	f.Add(uint8(4), words[20000:20000+fuzzLenMax], uint16(1), uint8(4), true)
+	f.Add(uint8(2), bytes.Repeat([]byte("ab"), fuzzLenMax/2), uint16(100), uint8(255), true)
+	f.Add(uint8(0), make([]byte, fuzzLenMax), uint16(3), uint8(100), false)
+	f.Fuzz(func(t *testing.T, kind uint8, data []byte, size uint16, niceLen uint8, skip bool) {
+		if len(data) > fuzzLenMax {
+			data = data[:fuzzLenMax]
+		}
+		if size == 0 {
+			size = 1
+		}
+		if niceLen < 4 {
+			niceLen = 4
+		}
+		fuzzFind(t, kinds[int(kind)%len(kinds)], data, int(size), uint32(niceLen), skip)
+	})
+}
+
+// benchmarkFind runs a greedy parse of the words data, skipping the
+// rest of each match of at least minLen bytes.
+func benchmarkFind(b *testing.B, kind matchfinder.Kind) {
+	data := readWords(b)
+	mf, err := matchfinder.New(kind, 1<<20, 64, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var matches []matchfinder.Match
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mf.Reset()
+		mf.Fill(data)
+		mf.Finish()
+		for mf.Avail() > 0 {
+			matches = mf.Find(matches[:0])
+			if n := len(matches); n > 0 && int(matches[n-1].Len) >= minLen(kind) {
+				mf.Skip(int(matches[n-1].Len) - 1)
+			}
+		}
+	}
+}
+
+func BenchmarkHC3(b *testing.B) { benchmarkFind(b, matchfinder.HC3) }
+func BenchmarkHC4(b *testing.B) { benchmarkFind(b, matchfinder.HC4) }
+func BenchmarkBT2(b *testing.B) { benchmarkFind(b, matchfinder.BT2) }
+func BenchmarkBT3(b *testing.B) { benchmarkFind(b, matchfinder.BT3) }
+func BenchmarkBT4(b *testing.B) { benchmarkFind(b, matchfinder.BT4) }
@@ -0,0 +1,214 @@
+/*
+ * Package xz Go parallel Reader API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"io"
+	"math"
+	"runtime"
+)
+
+// A ParallelReader is an io.Reader that decompresses the blocks of an
+// XZ file concurrently. The uncompressed data is returned in order, as
+// it would be by a Reader.
+//
+// Each worker decompresses a whole block into memory, so the memory
+// used is roughly the number of workers multiplied by the sum of the
+// dictionary size and the uncompressed block size. Blocks too large
+// to be held in memory are instead decompressed as they are read,
+// without the help of the workers. Files containing many blocks are
+// created for example by multi-threaded compression with XZ Utils
+// ("xz -T0").
+type ParallelReader struct {
+	z       *ReaderAt         // provides the Index and block decoding
+	serial  bool              // true if blocks are decoded one by one
+	next    int               // index of the next block to dispatch
+	jobs    []*blockJob       // dispatched blocks in order
+	decs    chan *blockReader // block decoders not in use
+	workers int               // maximum number of jobs in flight
+	out     []byte            // unread data of the current block
+	br      *blockReader      // decoder of the current block, or nil
+	err     error             // sticky error
+}
+
+// A blockJob is the decompression of a single block by a worker.
+type blockJob struct {
+	data []byte        // the uncompressed block
+	br   *blockReader  // decoder of a block too large to buffer, or nil
+	err  error         // the result of decoding the block
+	done chan struct{} // closed when the job is complete
+}
+
+// blockBufMax is the size of the largest block a ParallelReader holds
+// in memory when no memory limit is set. It is above the block size
+// used by multi-threaded compression with XZ Utils at any preset.
+const blockBufMax = 1 << 28 // 256 MiB
+
+// NewParallelReader creates a new ParallelReader reading the XZ file
+// held in the first size bytes of r, using up to workers goroutines
+// at once. Passing a value of zero or less for workers sets it to
+// runtime.GOMAXPROCS(0). dictMax has the same meaning as for
+// NewReader.
+//
+// Files with fewer than two blocks, or a workers value of one, are
+// decompressed serially, without buffering whole blocks in memory.
+//
+// The error returned for streams using an unsupported check type is
+// the same as for NewReaderAt.
+func NewParallelReader(r io.ReaderAt, size int64, dictMax uint32, workers int) (*ParallelReader, error) {
+	return NewParallelReaderOptions(r, size, workers,
+		&ReaderOptions{DictMax: dictMax})
+}
+
+// NewParallelReaderOptions is like NewParallelReader but takes its
+// settings from opts, which are used as by NewReaderAtOptions. A nil
+// opts is equivalent to a zero ReaderOptions.
+//
+// MemLimit limits the memory used to decode each block, counting the
+// Index, the block decoder and its dictionary and filters, and the
+// buffer holding the whole uncompressed block. A block which would
+// need more is decompressed as it is read, without a buffer, and
+// only if the decoder alone needs more does Read return ErrMemlimit.
+// Without MemLimit, blocks larger than 256 MiB are not buffered. The
+// memory used by all the workers together may be up to workers times
+// MemLimit.
+func NewParallelReaderOptions(r io.ReaderAt, size int64, workers int, opts *ReaderOptions) (*ParallelReader, error) {
+	z, err := NewReaderAtOptions(r, size, opts)
+	if z == nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &ParallelReader{
+		z:       z,
+		serial:  workers == 1 || len(z.index.blocks) < 2,
+		workers: workers,
+	}
+	if !p.serial {
+		p.decs = make(chan *blockReader, workers)
+		for i := 0; i < workers; i++ {
+			p.decs <- nil // allocated when first needed
+		}
+	}
+	return p, err
+}
+
+// dispatch starts the decompression of blocks until workers jobs are
+// in flight or there are no more blocks.
+func (p *ParallelReader) dispatch() {
+	blocks := p.z.index.blocks
+	for len(p.jobs) < p.workers && p.next < len(blocks) {
+		i := p.next
+		p.next++
+		if blocks[i].uncompressed == 0 {
+			continue
+		}
+		j := &blockJob{done: make(chan struct{})}
+		p.jobs = append(p.jobs, j)
+		go func() {
+			br := <-p.decs
+			if br == nil {
+				br = newBlockReader(p.z)
+			}
+			br.reset(p.z, i)
+			if j.err = br.header(p.z); j.err == nil {
+				j.err = checkBlockSize(&blocks[i])
+			}
+			switch {
+			case j.err != nil:
+			case bufferBlock(p.z, br, &blocks[i]):
+				j.data = make([]byte, blocks[i].uncompressed)
+				_, j.err = br.decode(p.z, j.data)
+			default:
+				// keep br to decode the block as it is read
+				j.br = br
+				close(j.done)
+				return
+			}
+			p.decs <- br
+			close(j.done)
+		}()
+	}
+}
+
+// checkBlockSize checks that the uncompressed size of blk in the
+// Index is plausible for its compressed size.
+func checkBlockSize(blk *xzIndexBlock) error {
+	if blk.uncompressed/ratioMax > blk.unpadded {
+		return ErrData
+	}
+	return nil
+}
+
+// bufferBlock reports whether blk is small enough to be held in memory,
+// together with the decoder br whose header has been decoded.
+func bufferBlock(z *ReaderAt, br *blockReader, blk *xzIndexBlock) bool {
+	if blk.uncompressed > math.MaxInt {
+		return false
+	}
+	if z.memLimit == 0 {
+		return blk.uncompressed <= blockBufMax
+	}
+	return br.dec.memRequired+uint64(blk.uncompressed) <= z.memLimit
+}
+
+// Read implements the io.Reader interface.
+func (p *ParallelReader) Read(b []byte) (n int, err error) {
+	if p.serial {
+		return p.z.Read(b)
+	}
+	for len(p.out) == 0 && p.br == nil {
+		if p.err != nil {
+			return 0, p.err
+		}
+		p.dispatch()
+		if len(p.jobs) == 0 {
+			p.err = io.EOF
+			continue
+		}
+		j := p.jobs[0]
+		<-j.done
+		p.jobs = p.jobs[1:]
+		if j.err != nil {
+			p.err = j.err
+			continue
+		}
+		p.out, p.br = j.data, j.br
+		// keep the workers busy while the data is read
+		p.dispatch()
+	}
+	if p.br != nil {
+		return p.readBlock(b)
+	}
+	n = copy(b, p.out)
+	p.out = p.out[n:]
+	return n, nil
+}
+
+// readBlock decodes into b the data of the block too large to buffer
+// which p.br is decoding.
+func (p *ParallelReader) readBlock(b []byte) (n int, err error) {
+	br := p.br
+	blk := &p.z.index.blocks[br.block]
+	if int64(len(b)) > blk.uncompressed-br.pos {
+		b = b[:blk.uncompressed-br.pos]
+	}
+	n, err = br.decode(p.z, b)
+	if err != nil {
+		br.block = -1
+		p.err = err
+	}
+	if err != nil || br.pos == blk.uncompressed {
+		p.br = nil
+		p.decs <- br
+	}
+	return n, err
+}
@@ -0,0 +1,150 @@
+/*
+ * Package xz ParallelReader tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/xi2/xz"
+)
+
+func TestParallelReader(t *testing.T) {
+	file, data := multiBlockFile(t)
+	for _, workers := range []int{0, 1, 2, 3, 16} {
+		r, err := xz.NewParallelReader(
+			bytes.NewReader(file), int64(len(file)), 0, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("%d workers: returned different data\n", workers)
+		}
+	}
+}
+
+func TestParallelReaderByteReads(t *testing.T) {
+	file, data := multiBlockFile(t)
+	r, err := xz.NewParallelReader(bytes.NewReader(file), int64(len(file)), 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatal("returned different data")
+	}
+}
+
+func TestParallelReaderFiles(t *testing.T) {
+	for _, f := range []testFile{
+		{file: "good-0cat-empty.xz"},
+		{file: "good-1-x86-lzma2.xz"},
+		{file: "good-2-lzma2.xz"},
+		{file: "bad-1-check-crc64.xz", err: xz.ErrData},
+		{file: "bad-2-index-2.xz", err: xz.ErrData},
+		{file: "good-2-lzma2-corrupt.xz", err: xz.ErrData},
+	} {
+		file, err := readTestFile(f.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := xz.NewParallelReader(
+			bytes.NewReader(file), int64(len(file)), 0, 2)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if err != f.err {
+			t.Fatalf("%s: wanted error: %v, got: %v\n", f.file, f.err, err)
+		}
+	}
+}
+
+func TestParallelReaderForgedIndex(t *testing.T) {
+	// block sizes in the Index which are inconsistent with the block
+	// header, or implausible for the compressed size, are rejected
+	// before the block is allocated
+	for _, test := range []struct {
+		file  string
+		block int
+		size  uint64
+	}{
+		{"words-blocks.xz", 1, 1 << 60},
+		{"words-blocks.xz", 2, 16383},
+		{"good-2-lzma2.xz", 1, 1 << 60},
+		{"good-2-lzma2.xz", 0, 1 << 30},
+	} {
+		file, err := readTestFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file = setIndexSize(t, file, test.block, test.size)
+		r, err := xz.NewParallelReader(
+			bytes.NewReader(file), int64(len(file)), 0, 2)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if err != xz.ErrData {
+			t.Fatalf("%+v: wanted error: %v, got: %v\n", test, xz.ErrData, err)
+		}
+	}
+}
+
+func TestParallelReaderMemLimit(t *testing.T) {
+	// blocks of words-blocks.xz whose 16 KiB buffer does not fit
+	// within the limit are decoded as they are read
+	file, err := readTestFile("words-blocks.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := decodeTestFile(t, "words-blocks.xz")
+	_, err = xz.NewReaderOptions(bytes.NewReader(file),
+		&xz.ReaderOptions{MemLimit: 1})
+	var e *xz.Error
+	if !errors.As(err, &e) || e.Err != xz.ErrMemlimit {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
+	}
+	// the memory a Reader needs, plus enough for the Index
+	memLimit := e.MemRequired + 1<<12
+	for _, test := range []struct {
+		memLimit uint64
+		workers  int
+		err      error
+	}{
+		{e.MemRequired, 2, xz.ErrMemlimit},
+		{memLimit, 1, nil},
+		{memLimit, 2, nil},
+		{memLimit + 1<<13, 3, nil},
+		{memLimit + 1<<14, 2, nil},
+	} {
+		r, err := xz.NewParallelReaderOptions(bytes.NewReader(file),
+			int64(len(file)), test.workers,
+			&xz.ReaderOptions{MemLimit: test.memLimit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(iotest.OneByteReader(r))
+		if err != test.err {
+			t.Fatalf("%+v: wanted error: %v, got: %v\n", test, test.err, err)
+		}
+		if err == nil && !bytes.Equal(b, data) {
+			t.Fatalf("%+v: returned different data\n", test)
+		}
+	}
+}
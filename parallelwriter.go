@@ -0,0 +1,281 @@
+/*
+ * Package xz Go parallel Writer API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"io"
+	"runtime"
+)
+
+// DefaultBlockSize is the uncompressed size of the blocks written by a
+// ParallelWriter unless another size is given to NewParallelWriter.
+// It is three times the dictionary size, the same as the default of
+// XZ Utils when compressing with multiple threads.
+const DefaultBlockSize = 3 << 23 // 24 MiB
+
+// minBlockSize is the smallest default block size, used when three
+// times the dictionary size of a Preset is smaller.
+const minBlockSize = 1 << 20
+
+// A ParallelWriter is an io.WriteCloser which compresses like a
+// Writer but splits its input into blocks of a fixed size and
+// compresses the blocks concurrently. The result is a single XZ
+// stream, equivalent to that of multi-threaded compression with XZ
+// Utils ("xz -T0").
+//
+// The compressed and uncompressed sizes of every block are stored in
+// its block header, and the blocks are listed in the stream's index,
+// so the output can be read with ReaderAt and decompressed in
+// parallel by ParallelReader.
+//
+// Each worker holds its own encoder and a whole block of input and
+// output, so the memory used is roughly the number of workers
+// multiplied by the sum of the encoder's memory use and twice the
+// block size.
+type ParallelWriter struct {
+	Header
+	w           io.Writer       // the wrapped io.Writer
+	blockSize   int             // uncompressed size of each block
+	workers     int             // maximum number of jobs in flight
+	opts        lzma2Options    // encoder options used for every block
+	wroteHeader bool            // true after the stream header is written
+	closed      bool            // true after Close has been called
+	cur         *encJob         // the block being filled by Write
+	jobs        []*encJob       // dispatched blocks in order
+	free        []*encJob       // finished jobs whose buffers can be reused
+	encs        chan *xzEnc     // block encoders not in use
+	index       []xzIndexRecord // records of the blocks written
+	err         error           // sticky error
+}
+
+// An encJob is the compression of a single block by a worker.
+type encJob struct {
+	in   []byte        // the uncompressed block
+	out  []byte        // the compressed block
+	rec  xzIndexRecord // the index record of the block
+	done chan struct{} // closed when the job is complete
+}
+
+// NewParallelWriter returns a new ParallelWriter. Writes to the
+// returned ParallelWriter are compressed and written to w, in blocks
+// holding blockSize bytes of uncompressed data, using up to workers
+// goroutines at once. Passing a value of zero or less for blockSize
+// sets it to DefaultBlockSize, and for workers sets it to
+// runtime.GOMAXPROCS(0).
+//
+// The dictionary size used is that of Writer, reduced if needed to
+// the smallest size which holds a whole block, as no match can reach
+// outside its block.
+//
+// As for Writer, it is the caller's responsibility to call Close on
+// the ParallelWriter when done, and the fields in
+// ParallelWriter.Header must be set before the first call to Write or
+// Close.
+func NewParallelWriter(w io.Writer, blockSize int, workers int) *ParallelWriter {
+	return newParallelWriter(w, lzma2DefaultOptions, blockSize, workers)
+}
+
+// NewParallelWriterPreset is like NewParallelWriter but compresses
+// using the options selected by preset. The default block size is
+// three times the preset's dictionary size, but at least 1 MiB, as
+// for XZ Utils. It returns an error if preset is invalid.
+func NewParallelWriterPreset(w io.Writer, preset Preset, blockSize int, workers int) (*ParallelWriter, error) {
+	opts, err := presetOptions(preset)
+	if err != nil {
+		return nil, err
+	}
+	return newParallelWriter(w, opts, blockSize, workers), nil
+}
+
+func newParallelWriter(w io.Writer, opts lzma2Options, blockSize int, workers int) *ParallelWriter {
+	if blockSize <= 0 {
+		blockSize = 3 * int(opts.dictSize)
+		if blockSize < minBlockSize {
+			blockSize = minBlockSize
+		}
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	z := &ParallelWriter{
+		blockSize: blockSize,
+		workers:   workers,
+		opts:      opts,
+		encs:      make(chan *xzEnc, workers),
+	}
+	if int64(blockSize) < int64(z.opts.dictSize) {
+		z.opts.dictSize, _ = lzma2DictSize(lzma2DictProps(uint32(blockSize)))
+	}
+	for i := 0; i < workers; i++ {
+		z.encs <- nil // allocated when first needed
+	}
+	z.init(w)
+	return z
+}
+
+func (z *ParallelWriter) init(w io.Writer) {
+	z.Header = Header{CheckType: DefaultCheck}
+	z.w = w
+	z.wroteHeader = false
+	z.closed = false
+	z.index = z.index[:0]
+	z.err = nil
+}
+
+// write writes p to the underlying io.Writer.
+func (z *ParallelWriter) write(p []byte) error {
+	if len(p) > 0 {
+		_, z.err = z.w.Write(p)
+	}
+	return z.err
+}
+
+// writeHeader checks the check type and writes the stream header.
+func (z *ParallelWriter) writeHeader() error {
+	// the encoder is only used to validate the check type
+	if xzEncSetCheck(new(xzEnc), z.CheckType) != xzOK {
+		z.err = ErrUnsupportedCheck
+		return z.err
+	}
+	z.wroteHeader = true
+	return z.write(encStreamHeader(nil, z.CheckType))
+}
+
+// newJob returns a job with empty buffers, reusing a finished one if
+// there is one.
+func (z *ParallelWriter) newJob() *encJob {
+	if n := len(z.free); n > 0 {
+		j := z.free[n-1]
+		z.free = z.free[:n-1]
+		j.in = j.in[:0]
+		j.out = j.out[:0]
+		return j
+	}
+	return new(encJob)
+}
+
+// dispatch starts the compression of the current block, first
+// writing the oldest block if workers jobs are already in flight.
+func (z *ParallelWriter) dispatch() error {
+	if len(z.jobs) == z.workers {
+		if err := z.writeJob(); err != nil {
+			return err
+		}
+	}
+	j := z.cur
+	z.cur = nil
+	j.done = make(chan struct{})
+	z.jobs = append(z.jobs, j)
+	checkType := z.CheckType
+	go func() {
+		s := <-z.encs
+		if s == nil {
+			s = xzEncInit(z.opts)
+		}
+		xzEncSetCheck(s, checkType)
+		j.out, j.rec = xzEncBlock(s, j.in, j.out)
+		z.encs <- s
+		close(j.done)
+	}()
+	return nil
+}
+
+// writeJob waits for the oldest job in flight to finish and writes
+// its block to the underlying io.Writer.
+func (z *ParallelWriter) writeJob() error {
+	j := z.jobs[0]
+	<-j.done
+	z.jobs = z.jobs[1:]
+	z.free = append(z.free, j)
+	z.index = append(z.index, j.rec)
+	return z.write(j.out)
+}
+
+// Write writes a compressed form of p to the underlying io.Writer.
+// The compressed bytes are not necessarily flushed until the
+// ParallelWriter is closed.
+func (z *ParallelWriter) Write(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errWriterClosed
+	}
+	if !z.wroteHeader {
+		if err = z.writeHeader(); err != nil {
+			return 0, err
+		}
+	}
+	for len(p) > 0 {
+		if z.cur == nil {
+			z.cur = z.newJob()
+		}
+		chunk := p
+		if space := z.blockSize - len(z.cur.in); len(chunk) > space {
+			chunk = chunk[:space]
+		}
+		z.cur.in = append(z.cur.in, chunk...)
+		n += len(chunk)
+		p = p[len(chunk):]
+		if len(z.cur.in) == z.blockSize {
+			if err = z.dispatch(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close closes the ParallelWriter by compressing any remaining input,
+// waiting for all blocks to be written to the underlying io.Writer and
+// writing the XZ index and stream footer. It does not close the
+// underlying io.Writer.
+func (z *ParallelWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if !z.wroteHeader {
+		if err := z.writeHeader(); err != nil {
+			return err
+		}
+	}
+	if z.cur != nil && len(z.cur.in) > 0 {
+		if err := z.dispatch(); err != nil {
+			return err
+		}
+	}
+	for len(z.jobs) > 0 {
+		if err := z.writeJob(); err != nil {
+			return err
+		}
+	}
+	return z.write(encIndex(nil, z.index, z.CheckType))
+}
+
+// Reset discards the ParallelWriter z's state and makes it equivalent
+// to the result of its original state from NewParallelWriter, but
+// writing to w instead. Blocks still being compressed are waited for
+// and discarded.
+func (z *ParallelWriter) Reset(w io.Writer) {
+	for _, j := range z.jobs {
+		<-j.done
+		z.free = append(z.free, j)
+	}
+	z.jobs = z.jobs[:0]
+	if z.cur != nil {
+		z.free = append(z.free, z.cur)
+		z.cur = nil
+	}
+	z.init(w)
+}
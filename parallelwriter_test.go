@@ -0,0 +1,259 @@
+/*
+ * Package xz ParallelWriter tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+// parallelCompress compresses data with a ParallelWriter using the
+// given block size and number of workers, writing it in pieces of at
+// most size bytes.
+func parallelCompress(t *testing.T, data []byte, blockSize, workers, size int) []byte {
+	c := new(bytes.Buffer)
+	w := xz.NewParallelWriter(c, blockSize, workers)
+	for p := data; len(p) > 0; {
+		n := size
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return c.Bytes()
+}
+
+func TestParallelWriter(t *testing.T) {
+	data := append(decodeTestFile(t, "words.xz"),
+		decodeTestFile(t, "random-1mb.xz")...)
+	for _, test := range []struct {
+		blockSize int
+		workers   int
+		size      int
+	}{
+		{0, 0, 1 << 20},
+		{1 << 16, 1, 1 << 20},
+		{1 << 16, 3, 1000},
+		{100000, 16, 1 << 16},
+		{len(data), 2, 1 << 20},
+	} {
+		file := parallelCompress(t, data, test.blockSize, test.workers,
+			test.size)
+		r, err := xz.NewReader(bytes.NewReader(file), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("%+v: round trip returned different data", test)
+		}
+		pr, err := xz.NewParallelReader(
+			bytes.NewReader(file), int64(len(file)), 0, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err = ioutil.ReadAll(pr); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("%+v: ParallelReader returned different data", test)
+		}
+		// every block but the last is full
+		idx, err := xz.ReadIndex(bytes.NewReader(file), int64(len(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockSize := test.blockSize
+		if blockSize == 0 {
+			blockSize = xz.DefaultBlockSize
+		}
+		blocks := idx.Streams[0].Blocks
+		if want := (len(data) + blockSize - 1) / blockSize; len(blocks) != want {
+			t.Fatalf("%+v: wanted %d blocks, got %d", test, want, len(blocks))
+		}
+		for i, blk := range blocks[:len(blocks)-1] {
+			if blk.UncompressedSize != int64(blockSize) {
+				t.Fatalf("%+v: block %d has size %d", test, i,
+					blk.UncompressedSize)
+			}
+		}
+	}
+}
+
+func TestParallelWriterBlockHeaders(t *testing.T) {
+	// the block headers hold the sizes stored in the index, which
+	// also counts the 8 byte CRC64 check
+	data := decodeTestFile(t, "words.xz")
+	file := parallelCompress(t, data, 1<<14, 4, len(data))
+	idx, err := xz.ReadIndex(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewReader(bytes.NewReader(file), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, blk := range idx.Streams[0].Blocks {
+		h, err := r.NextBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Offset != blk.Offset ||
+			h.UncompressedSize != blk.UncompressedSize ||
+			h.CompressedSize != blk.UnpaddedSize-int64(h.HeaderSize)-8 {
+			t.Fatalf("block %d: header %+v does not match index %+v",
+				i, *h, blk)
+		}
+		// the dictionary is reduced to the block size
+		if h.DictSize != 1<<14 {
+			t.Fatalf("block %d: wanted dictionary size %d, got %d",
+				i, 1<<14, h.DictSize)
+		}
+	}
+	if _, err = r.NextBlock(); err != io.EOF {
+		t.Fatalf("wanted error: %v, got: %v", io.EOF, err)
+	}
+}
+
+func TestParallelWriterEmpty(t *testing.T) {
+	// as for Writer, an empty stream with a CRC32 check is
+	// identical to good-0-empty.xz from XZ Utils
+	want, err := readTestFile("good-0-empty.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(bytes.Buffer)
+	w := xz.NewParallelWriter(c, 0, 0)
+	w.CheckType = xz.CheckCRC32
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Bytes(), want) {
+		t.Fatalf("wanted: %x, got: %x\n", want, c.Bytes())
+	}
+}
+
+func TestParallelWriterReset(t *testing.T) {
+	data := decodeTestFile(t, "words.xz")
+	want := parallelCompress(t, data, 1<<12, 4, len(data))
+	w := xz.NewParallelWriter(ioutil.Discard, 1<<12, 4)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	c := new(bytes.Buffer)
+	w.Reset(c)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Bytes(), want) {
+		t.Fatal("output after Reset differs")
+	}
+}
+
+func TestParallelWriterUnsupportedCheck(t *testing.T) {
+	w := xz.NewParallelWriter(new(bytes.Buffer), 0, 0)
+	w.CheckType = 0x02
+	if _, err := w.Write([]byte("data")); err != xz.ErrUnsupportedCheck {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrUnsupportedCheck, err)
+	}
+	if err := w.Close(); err != xz.ErrUnsupportedCheck {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrUnsupportedCheck, err)
+	}
+}
+
+func benchmarkParallelWriter(b *testing.B, workers int) {
+	data, err := readTestFile("words.xz")
+	if err != nil {
+		b.Fatal(err)
+	}
+	r, err := xz.NewReader(bytes.NewReader(data), 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if data, err = ioutil.ReadAll(r); err != nil {
+		b.Fatal(err)
+	}
+	for len(data) < 1<<22 {
+		data = append(data, data...)
+	}
+	w := xz.NewParallelWriter(ioutil.Discard, 1<<18, workers)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset(ioutil.Discard)
+		if _, err = w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelWriter1(b *testing.B) {
+	benchmarkParallelWriter(b, 1)
+}
+
+func BenchmarkParallelWriter4(b *testing.B) {
+	benchmarkParallelWriter(b, 4)
+}
+
+func TestParallelWriterPreset(t *testing.T) {
+	// with preset 0 the default block size is 1 MiB rather than
+	// three times the 256 KiB dictionary
+	data := decodeTestFile(t, "random-1mb.xz")
+	data = append(data, data...)
+	c := new(bytes.Buffer)
+	w, err := xz.NewParallelWriterPreset(c, 0, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := xz.ReadIndex(bytes.NewReader(c.Bytes()), int64(c.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := idx.Streams[0].Blocks
+	if len(blocks) != 2 || blocks[0].UncompressedSize != 1<<20 {
+		t.Fatalf("wanted two blocks of 1 MiB, got %+v", blocks)
+	}
+	r, err := xz.NewReader(c, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := r.NextBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.DictSize != 256<<10 {
+		t.Fatalf("wanted dictionary size %d, got %d", 256<<10, h.DictSize)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Package xz Go dictionary pool
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import "sync"
+
+// A DictPool holds LZMA2 dictionary buffers for reuse by Readers
+// created with NewReaderOptions. Each buffer is the size of the
+// dictionary of the stream which needed it, not the maximum
+// dictionary size, and buffers are returned to the pool by
+// Reader.Close. Pooling the dictionaries reduces garbage collection
+// work when many short-lived Readers are used, possibly
+// concurrently.
+//
+// The zero value is an empty pool ready to use. A DictPool is safe
+// for use by multiple goroutines.
+type DictPool struct {
+	mu    sync.Mutex
+	pools map[int]*sync.Pool // pools of buffers keyed by size
+}
+
+// pool returns the pool holding buffers of the given size.
+func (p *DictPool) pool(size int) *sync.Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pools == nil {
+		p.pools = make(map[int]*sync.Pool)
+	}
+	sp := p.pools[size]
+	if sp == nil {
+		sp = new(sync.Pool)
+		p.pools[size] = sp
+	}
+	return sp
+}
+
+// get returns a buffer of the given size.
+func (p *DictPool) get(size int) []byte {
+	if buf, ok := p.pool(size).Get().(*[]byte); ok {
+		return *buf
+	}
+	return make([]byte, size)
+}
+
+// put returns buf to the pool.
+func (p *DictPool) put(buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	p.pool(len(buf)).Put(&buf)
+}
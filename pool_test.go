@@ -0,0 +1,101 @@
+/*
+ * Package xz DictPool tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+func TestDictPool(t *testing.T) {
+	files := []string{
+		"words.xz", "words-blocks.xz", "good-1-x86-lzma2.xz",
+		"good-1-delta-lzma2.tiff.xz", "good-2-lzma2.xz",
+	}
+	pool := new(xz.DictPool)
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(files))
+	for i := 0; i < 4; i++ {
+		for _, file := range files {
+			wg.Add(1)
+			go func(file string) {
+				defer wg.Done()
+				data, err := readTestFile(file)
+				if err != nil {
+					errs <- err
+					return
+				}
+				z, err := xz.NewReaderOptions(bytes.NewReader(data),
+					&xz.ReaderOptions{DictPool: pool})
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer z.Close()
+				hash := md5.New()
+				if _, err = io.Copy(hash, z); err != nil {
+					errs <- err
+					return
+				}
+				md5sum := fmt.Sprintf("%x", hash.Sum(nil))
+				if wantedMD5, _ := testFileData(file); wantedMD5 != md5sum {
+					errs <- fmt.Errorf("%s: wanted md5: %v, got: %v",
+						file, wantedMD5, md5sum)
+				}
+			}(file)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestReaderClose(t *testing.T) {
+	data, err := readTestFile("words.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := new(xz.DictPool)
+	z, err := xz.NewReaderOptions(bytes.NewReader(data),
+		&xz.ReaderOptions{DictPool: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1000)
+	if _, err = io.ReadFull(z, b); err != nil {
+		t.Fatal(err)
+	}
+	if err = z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.Read(b); err == nil {
+		t.Fatal("Read after Close succeeded")
+	}
+	// Reset makes the Reader usable again
+	if err = z.Reset(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	hash := md5.New()
+	if _, err = io.Copy(hash, z); err != nil {
+		t.Fatal(err)
+	}
+	md5sum := fmt.Sprintf("%x", hash.Sum(nil))
+	if wantedMD5, _ := testFileData("words.xz"); wantedMD5 != md5sum {
+		t.Fatalf("wanted md5: %v, got: %v\n", wantedMD5, md5sum)
+	}
+}
@@ -10,11 +10,14 @@
 package xz
 
 import (
+	"context"
 	"errors"
 	"io"
+	"math"
 )
 
-// Package specific errors.
+// Package specific errors. The errors returned by Reader when
+// decompression fails are of type *Error, which wrap these.
 var (
 	ErrUnsupportedCheck = errors.New("xz: integrity check type not supported")
 	ErrMemlimit         = errors.New("xz: LZMA2 dictionary size exceeds max")
@@ -22,16 +25,86 @@ var (
 	ErrOptions          = errors.New("xz: compression options not supported")
 	ErrData             = errors.New("xz: data is corrupt")
 	ErrBuf              = errors.New("xz: data is truncated or corrupt")
+	ErrOutputLimit      = errors.New("xz: uncompressed size exceeds limit")
 )
 
+// errClosed is returned when reading from a closed Reader.
+var errClosed = errors.New("xz: read from closed Reader")
+
+// retError returns the package specific error corresponding to a
+// decoder return value other than xzOK or xzStreamEnd.
+func retError(ret xzRet) error {
+	switch ret {
+	case xzUnsupportedCheck:
+		return ErrUnsupportedCheck
+	case xzMemlimitError:
+		return ErrMemlimit
+	case xzFormatError:
+		return ErrFormat
+	case xzOptionsError:
+		return ErrOptions
+	case xzDataError:
+		return ErrData
+	case xzBufError:
+		return ErrBuf
+	case xzOutputLimitError:
+		return ErrOutputLimit
+	}
+	return nil
+}
+
 // DefaultDictMax is the default maximum dictionary size in bytes used
 // by the decoder. This value is sufficient to decompress files
 // created with XZ Utils "xz -9".
 const DefaultDictMax = 1 << 26 // 64 MiB
 
+// newError returns an Error describing the decoder return value ret,
+// which is other than xzOK or xzStreamEnd. The decoder s was at the
+// given input offset, stream and uncompressed offset, and padding is
+// true if stream padding was being decoded.
+func newError(s *xzDec, ret xzRet, offset int64, stream int, uncompressed int64, padding bool) *Error {
+	e := &Error{
+		Err:                retError(ret),
+		Reason:             s.reason,
+		Offset:             offset,
+		Stream:             stream,
+		Block:              -1,
+		UncompressedOffset: uncompressed,
+	}
+	if ret == xzMemlimitError {
+		e.MemRequired = s.memRequired
+	}
+	if !padding {
+		// block.count includes the current block once its
+		// compressed data has been decoded
+		switch s.sequence {
+		case seqBlockHeader, seqBlockUncompress:
+			e.Block = int(s.block.count)
+		case seqBlockPadding, seqBlockCheck:
+			e.Block = int(s.block.count) - 1
+		}
+	}
+	return e
+}
+
+// newError returns an Error describing the decoder return value ret,
+// which is other than xzOK or xzStreamEnd.
+func (z *Reader) newError(ret xzRet) *Error {
+	return newError(z.dec, ret, z.consumed+int64(z.buf.inPos),
+		z.streams, z.produced, z.padding >= 0)
+}
+
 // inBufSize is the input buffer size used by the decoder.
 const inBufSize = 1 << 13 // 8 KiB
 
+// writeToBufSize is the largest amount of data written by each call
+// to the io.Writer passed to WriteTo.
+const writeToBufSize = 1 << 16 // 64 KiB
+
+// ctxOutSize is the largest amount of data decoded between checks of
+// a Reader's context.
+const ctxOutSize = 1 << 20 // 1 MiB
+
 // A Reader is an io.Reader that can be used to retrieve uncompressed
 // data from an XZ file.
 //
@@ -40,15 +113,31 @@ const inBufSize = 1 << 13 // 8 KiB
 // uncompressed data of each.
 type Reader struct {
 	Header
-	r           io.Reader       // the wrapped io.Reader
-	multistream bool            // true if reader is in multistream mode
-	rEOF        bool            // true after io.EOF received on r
-	dEOF        bool            // true after decoder has completed
-	padding     int             // bytes of stream padding read (or -1)
-	in          [inBufSize]byte // backing array for buf.in
-	buf         *xzBuf          // decoder input/output buffers
-	dec         *xzDec          // decoder state
-	err         error           // the result of the last decoder call
+	r           io.Reader          // the wrapped io.Reader
+	ctx         context.Context    // checked while decoding, or nil
+	multistream bool               // true if reader is in multistream mode
+	rEOF        bool               // true after io.EOF received on r
+	dEOF        bool               // true after decoder has completed
+	padding     int                // bytes of stream padding read (or -1)
+	consumed    int64              // input bytes before those in buf.in
+	produced    int64              // uncompressed bytes decoded
+	streams     int                // number of streams decoded
+	maxOutput   int64              // uncompressed size limit (or <= 0)
+	size        int64              // size from the Index (or -1)
+	blocks      int                // number of blocks decoded
+	outStart    int                // buf.outPos before the decoder call
+	progress    func(Stats)        // progress callback, or nil
+	headerHook  func(*BlockHeader) // block header callback, or nil
+	blockMode   bool               // true if reader is in block mode
+	blockEOF    bool               // true at the end of a block in block mode
+	nextBlock   bool               // true while NextBlock finds a block
+	blockFound  bool               // true if NextBlock returned this block
+	blockOffset int64              // offset of the current block
+	blockStart  int64              // uncompressed offset of the current block
+	in          [inBufSize]byte    // backing array for buf.in
+	buf         *xzBuf             // decoder input/output buffers
+	dec         *xzDec             // decoder state
+	err         error              // the result of the last decoder call
 }
 
 // NewReader creates a new Reader reading from r. The decompressor
@@ -65,26 +154,104 @@ type Reader struct {
 // Due to internal buffering, the Reader may read more data than
 // necessary from r.
 func NewReader(r io.Reader, dictMax uint32) (*Reader, error) {
-	if dictMax == 0 {
-		dictMax = DefaultDictMax
+	return NewReaderOptions(r, &ReaderOptions{DictMax: dictMax})
+}
+
+// ReaderOptions holds optional settings for a Reader.
+type ReaderOptions struct {
+	// DictMax has the same meaning as the dictMax argument of
+	// NewReader.
+	DictMax uint32
+	// MemLimit, if not zero, limits the memory in bytes used to
+	// decode each block, counting the dictionary, any BCJ and
+	// Delta filters, and the Reader itself. If a block needs more,
+	// Read returns an *Error wrapping ErrMemlimit whose
+	// MemRequired field gives the memory needed. If MemLimit is
+	// set and DictMax is not, the dictionary size is limited only
+	// by MemLimit. NewReaderAtOptions, NewParallelReaderOptions
+	// and DecodeOptions apply MemLimit too, counting the memory
+	// their documentation describes.
+	MemLimit uint64
+	// MaxOutput, if greater than zero, limits the uncompressed
+	// data the Reader returns to MaxOutput bytes. Decoding stops
+	// with an *Error wrapping ErrOutputLimit as soon as a Block
+	// Header declares an uncompressed size which would exceed the
+	// limit, or otherwise once more data is decoded. The data up to
+	// the limit is returned before the error. If Size is known it
+	// is checked before decoding starts.
+	MaxOutput int64
+	// Progress, if not nil, is called with the Reader's Stats
+	// each time decoding crosses a block boundary, that is once a
+	// block's header has been decoded and once the block is
+	// complete, and at the end of each call to Read.
+	Progress func(Stats)
+	// BlockHeader, if not nil, is called with the header of each
+	// block as decoding of the block starts.
+	BlockHeader func(*BlockHeader)
+	// DictPool, if not nil, supplies the Reader's LZMA2
+	// dictionary. Call Close when done with the Reader to return
+	// the dictionary to the pool.
+	DictPool *DictPool
+}
+
+// dictMax returns the maximum dictionary size selected by opts.
+func (opts *ReaderOptions) dictMax() uint32 {
+	switch {
+	case opts.DictMax != 0:
+		return opts.DictMax
+	case opts.MemLimit != 0:
+		return math.MaxUint32
 	}
+	return DefaultDictMax
+}
+
+// NewReaderOptions is like NewReader but takes its settings from
+// opts. A nil opts is equivalent to a zero ReaderOptions.
+func NewReaderOptions(r io.Reader, opts *ReaderOptions) (*Reader, error) {
+	if opts == nil {
+		opts = &ReaderOptions{}
+	}
+	dictMax := opts.dictMax()
 	z := &Reader{
 		r:           r,
 		multistream: true,
 		padding:     -1,
+		size:        -1,
 		buf:         &xzBuf{},
 	}
 	if r == nil {
 		z.rEOF, z.dEOF = true, true
 	}
 	z.dec = xzDecInit(dictMax, &z.Header)
+	z.dec.lzma2.dict.pool = opts.DictPool
+	z.dec.memLimit = opts.MemLimit
+	z.dec.memCaller = memUsageReader
+	z.maxOutput = opts.MaxOutput
+	z.progress = opts.Progress
+	z.headerHook = opts.BlockHeader
+	z.dec.blockHook = z.blockBoundary
 	var err error
 	if r != nil {
+		z.readSize()
 		_, err = z.Read(nil) // read stream header
 	}
 	return z, err
 }
 
+// NewReaderContext is like NewReader but the Reader stops decoding
+// once ctx is done, after which Read returns ctx.Err(). The context is
+// checked each time more data is read from r and at least every MiB
+// of uncompressed data, so reads stop promptly even if r continues to
+// supply data. The context is kept by Reset.
+func NewReaderContext(ctx context.Context, r io.Reader, dictMax uint32) (*Reader, error) {
+	z, err := NewReader(nil, dictMax)
+	z.ctx = ctx
+	if r != nil {
+		err = z.Reset(r)
+	}
+	return z, err
+}
+
 // decode is a wrapper around xzDecRun that additionally handles
 // stream padding. It treats the padding as a kind of stream that
 // decodes to nothing.
@@ -103,7 +270,8 @@ func (z *Reader) decode() (ret xzRet) {
 		case z.buf.inPos == len(z.buf.in) && z.rEOF:
 			// case: out of padding. no more input data available
 			if z.padding%4 != 0 {
-				ret = xzDataError
+				ret = decError(z.dec, xzDataError,
+					"stream padding size not a multiple of four")
 			} else {
 				ret = xzStreamEnd
 			}
@@ -113,7 +281,8 @@ func (z *Reader) decode() (ret xzRet) {
 		default:
 			// case: out of padding. more input data available
 			if z.padding%4 != 0 {
-				ret = xzDataError
+				ret = decError(z.dec, xzDataError,
+					"stream padding size not a multiple of four")
 			} else {
 				xzDecReset(z.dec)
 				ret = xzStreamEnd
@@ -126,11 +295,18 @@ func (z *Reader) decode() (ret xzRet) {
 }
 
 func (z *Reader) Read(p []byte) (n int, err error) {
-	// restore err
-	err = z.err
 	// set decoder output buffer to p
 	z.buf.out = p
 	z.buf.outPos = 0
+	return z.read()
+}
+
+// read decodes data into z.buf.out until it is full, returning the
+// number of bytes decoded. If z.buf.direct is set the decoder may
+// replace z.buf.out with a slice of its dictionary.
+func (z *Reader) read() (n int, err error) {
+	// restore err
+	err = z.err
 	for {
 		// update n
 		n = z.buf.outPos
@@ -143,11 +319,25 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 			err = io.EOF
 			break
 		}
-		// if p full, return with err == nil, unless we have not yet
-		// read the stream header with Read(nil)
-		if n == len(p) && z.CheckType != checkUnset {
+		// in block mode, likewise at the end of a block
+		if z.blockEOF {
+			err = io.EOF
 			break
 		}
+		// if output full, return with err == nil, unless we have
+		// not yet read the stream header with Read(nil), or are
+		// finding the next block
+		if n == len(z.buf.out) && z.CheckType != checkUnset &&
+			!z.nextBlock {
+			break
+		}
+		// if the context is done, return its error
+		if z.ctx != nil {
+			if err = z.ctx.Err(); err != nil {
+				z.err = err
+				break
+			}
+		}
 		// if needed, read more data from z.r
 		if z.buf.inPos == len(z.buf.in) && !z.rEOF {
 			rn, e := z.r.Read(z.in[:])
@@ -160,11 +350,44 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 				z.rEOF = true
 			}
 			// set new input buffer in z.buf
+			z.consumed += int64(len(z.buf.in))
 			z.buf.in = z.in[:rn]
 			z.buf.inPos = 0
 		}
-		// decode more data
+		// decode more data, limiting the output between checks
+		// of the context, and to one byte beyond z.maxOutput so
+		// that exceeding it is detected
+		out := z.buf.out
+		outStart := z.buf.outPos
+		z.outStart = outStart
+		outMax := len(out) - outStart
+		if z.ctx != nil && outMax > ctxOutSize {
+			outMax = ctxOutSize
+		}
+		if z.maxOutput > 0 {
+			z.dec.outRemain = z.maxOutput - z.produced
+			if int64(outMax) > z.dec.outRemain {
+				outMax = int(z.dec.outRemain) + 1
+			}
+		}
+		limit := outMax < len(out)-outStart
+		if limit {
+			z.buf.out = out[:outStart+outMax]
+		}
 		ret := z.decode()
+		// in direct mode z.buf.out may now be a slice of the
+		// dictionary, and leaving it shortened is harmless
+		if limit && !z.buf.direct {
+			z.buf.out = out
+		}
+		z.produced += int64(z.buf.outPos - outStart)
+		if z.maxOutput > 0 && z.produced > z.maxOutput {
+			// drop the data beyond the limit
+			z.buf.outPos -= int(z.produced - z.maxOutput)
+			z.produced = z.maxOutput
+			ret = decError(z.dec, xzOutputLimitError,
+				"uncompressed data exceeds limit")
+		}
 		switch ret {
 		case xzOK:
 			// no action needed
@@ -176,26 +399,209 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 				}
 			} else {
 				z.padding = 0
+				z.streams++
 			}
-		case xzUnsupportedCheck:
-			err = ErrUnsupportedCheck
-		case xzMemlimitError:
-			err = ErrMemlimit
-		case xzFormatError:
-			err = ErrFormat
-		case xzOptionsError:
-			err = ErrOptions
-		case xzDataError:
-			err = ErrData
-		case xzBufError:
-			err = ErrBuf
+		default:
+			err = z.newError(ret)
 		}
 		// save err
 		z.err = err
 	}
+	if z.progress != nil {
+		z.progress(z.Stats())
+	}
 	return
 }
 
+// WriteTo implements the io.WriterTo interface. It writes the
+// remaining uncompressed data to w until there is no more data or an
+// error occurs, returning the number of bytes written. Where possible
+// data is written directly from the decoder's dictionary, avoiding
+// the copy made by Read, so io.Copy is faster with a Reader than with
+// other readers. The data of Blocks using BCJ or Delta filters is
+// written from an intermediate buffer.
+func (z *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	buf := make([]byte, writeToBufSize)
+	defer func() { z.buf.direct = false }()
+	for {
+		z.buf.out = buf
+		z.buf.outPos = 0
+		z.buf.direct = true
+		m, rerr := z.read()
+		if m > 0 {
+			wn, werr := w.Write(z.buf.out[:m])
+			n += int64(wn)
+			if werr != nil {
+				return n, werr
+			}
+			if wn != m {
+				return n, io.ErrShortWrite
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				rerr = nil
+			}
+			return n, rerr
+		}
+	}
+}
+
+// Stats describes the progress of a Reader through its input.
+type Stats struct {
+	Compressed   int64 // input bytes decoded
+	Uncompressed int64 // uncompressed bytes produced
+	Stream       int   // current stream number, counting from 0
+	Block        int   // current block number in the stream, or -1
+	Blocks       int   // number of blocks completed in all streams
+}
+
+// Stats returns the current progress of z. Block is -1 between
+// blocks, that is before a block's header has been decoded or after
+// the block is complete.
+func (z *Reader) Stats() Stats {
+	st := Stats{
+		Compressed:   z.consumed + int64(z.buf.inPos),
+		Uncompressed: z.produced,
+		Stream:       z.streams,
+		Block:        -1,
+		Blocks:       z.blocks,
+	}
+	if z.padding == -1 {
+		switch z.dec.sequence {
+		case seqBlockUncompress:
+			st.Block = int(z.dec.block.count)
+		case seqBlockPadding, seqBlockCheck:
+			st.Block = int(z.dec.block.count) - 1
+		}
+	}
+	return st
+}
+
+// blockBoundary is called by the decoder as it crosses a block
+// boundary, with start true at the start of a block.
+func (z *Reader) blockBoundary(start bool) {
+	if start {
+		z.blockOffset = z.consumed + int64(z.buf.inPos) -
+			int64(z.dec.blockHeader.size)
+		z.blockStart = z.produced + int64(z.buf.outPos-z.outStart)
+		z.blockFound = z.nextBlock
+		z.nextBlock = false
+		if z.headerHook != nil {
+			z.headerHook(z.blockHeader())
+		}
+	} else {
+		z.blocks++
+		z.blockEOF = z.blockMode
+	}
+	if z.progress != nil {
+		st := z.Stats()
+		// the data decoded by the current decoder call is not
+		// yet counted in z.produced
+		st.Uncompressed += int64(z.buf.outPos - z.outStart)
+		z.progress(st)
+	}
+}
+
+// blockHeader returns the header of the current block.
+func (z *Reader) blockHeader() *BlockHeader {
+	bh := &z.dec.blockHeader
+	h := &BlockHeader{
+		Offset:             z.blockOffset,
+		UncompressedOffset: z.blockStart,
+		HeaderSize:         bh.size,
+		CompressedSize:     -1,
+		UncompressedSize:   -1,
+		Filters:            make([]Filter, len(bh.filters)),
+	}
+	if bh.compressed != vliUnknown {
+		h.CompressedSize = int64(bh.compressed)
+	}
+	if bh.uncompressed != vliUnknown {
+		h.UncompressedSize = int64(bh.uncompressed)
+	}
+	for i, f := range bh.filters {
+		h.Filters[i] = Filter{ID: FilterID(f.id), Props: f.props}
+	}
+	h.DictSize = z.dec.lzma2.dict.size
+	return h
+}
+
+// seekReaderAt is an io.ReaderAt reading from an io.ReadSeeker at
+// offsets relative to base. It is not safe for concurrent use.
+type seekReaderAt struct {
+	r    io.ReadSeeker
+	base int64
+}
+
+func (ra seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := ra.r.Seek(ra.base+off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(ra.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readSize sets z.size from the Index of each stream if z.r is an
+// io.ReadSeeker, leaving z.r at its original position. The input is
+// then checked against z.maxOutput.
+func (z *Reader) readSize() {
+	z.size = -1
+	rs, ok := z.r.(io.ReadSeeker)
+	if !ok {
+		return
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err == nil && end > pos {
+		x, ret, err := decFileIndex(seekReaderAt{rs, pos}, end-pos,
+			z.dec.memLimit)
+		if err == nil && ret == xzOK {
+			z.size = x.uncompressed
+		}
+	}
+	if _, err = rs.Seek(pos, io.SeekStart); err != nil {
+		z.err = err
+		return
+	}
+	if z.maxOutput > 0 && z.size > z.maxOutput {
+		z.err = &Error{
+			Err:    ErrOutputLimit,
+			Reason: "index uncompressed size exceeds limit",
+			Block:  -1,
+		}
+	}
+}
+
+// Size returns the total size of the uncompressed data of all the
+// streams in the input, or -1 if it is not known. The size is known
+// before decompression starts if the io.Reader passed to NewReader
+// or Reset is an io.ReadSeeker and the data from its current
+// position to its end is an XZ file, in which case it is read from
+// the Index of each stream, provided the Index fits within the
+// MemLimit of ReaderOptions. The Index is verified as decompression
+// proceeds.
+func (z *Reader) Size() int64 {
+	return z.size
+}
+
+// Close releases the Reader's LZMA2 dictionary, returning it to the
+// DictPool if the Reader has one. Close does not close the underlying
+// io.Reader. After Close, Read returns an error until the Reader is
+// reinitialized by calling Reset with a non-nil io.Reader, after
+// which a new dictionary is obtained as needed.
+func (z *Reader) Close() error {
+	dictFree(&z.dec.lzma2.dict)
+	z.err = errClosed
+	return nil
+}
+
 // Multistream controls whether the reader is operating in multistream
 // mode.
 //
@@ -216,6 +622,54 @@ func (z *Reader) Multistream(ok bool) {
 	z.multistream = ok
 }
 
+// NextBlock puts the Reader in block mode, if it is not already, and
+// advances it to the start of the next block, returning the block's
+// header. Any unread data of the current block is discarded.
+//
+// In block mode, when the Reader reaches the end of a block, Read
+// returns io.EOF; call NextBlock to continue with the next. Blocks
+// of any following streams are read in turn, unless the Reader is
+// not in multistream mode. When there are no more blocks NextBlock
+// returns io.EOF. Block mode lasts until Reset is called with a
+// non-nil io.Reader.
+func (z *Reader) NextBlock() (*BlockHeader, error) {
+	if !z.blockMode {
+		z.blockMode = true
+		z.dec.blockStop = true
+	}
+	if !z.blockEOF && z.padding == -1 {
+		switch z.dec.sequence {
+		case seqBlockUncompress, seqBlockPadding, seqBlockCheck:
+			// return the current block if it was started when
+			// reading ahead and none of it has been read
+			if !z.blockFound && z.produced == z.blockStart {
+				z.blockFound = true
+				return z.blockHeader(), nil
+			}
+			// otherwise discard the rest of it
+			buf := make([]byte, inBufSize)
+			for !z.blockEOF {
+				z.buf.out = buf
+				z.buf.outPos = 0
+				if _, err := z.read(); err != nil && !z.blockEOF {
+					return nil, err
+				}
+			}
+		}
+	}
+	// decode up to the end of the next block header
+	z.blockEOF = false
+	z.nextBlock = true
+	z.buf.out = nil
+	z.buf.outPos = 0
+	_, err := z.read()
+	z.nextBlock = false
+	if err != nil {
+		return nil, err
+	}
+	return z.blockHeader(), nil
+}
+
 // Reset, for non-nil values of io.Reader r, discards the Reader z's
 // state and makes it equivalent to the result of its original state
 // from NewReader, but reading from r instead. This permits reusing a
@@ -246,10 +700,18 @@ func (z *Reader) Reset(r io.Reader) error {
 		z.rEOF = false
 		z.dEOF = false
 		z.padding = -1
+		z.consumed = 0
+		z.produced = 0
+		z.streams = 0
+		z.blocks = 0
+		z.blockMode = false
+		z.blockEOF = false
+		z.dec.blockStop = false
 		z.buf.in = nil
 		z.buf.inPos = 0
 		xzDecReset(z.dec)
 		z.err = nil
+		z.readSize()
 		_, err := z.Read(nil) // read stream header
 		return err
 	}
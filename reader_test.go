@@ -11,7 +11,9 @@ package xz_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -357,6 +359,16 @@ var unsupportedFiles = []testFile{
 }
 
 var otherFiles = []testFile{
+	{
+		file:   "good-1-arm64-lzma2.xz",
+		md5sum: "31504c527f621ef5380e154110552c16",
+		err:    nil,
+	},
+	{
+		file:   "good-1-arm64-lzma2-offset-2048.xz",
+		md5sum: "31504c527f621ef5380e154110552c16",
+		err:    nil,
+	},
 	{
 		file:   "good-1-x86-lzma2-offset-2048.xz",
 		md5sum: "ce212d6a1cfe73d8395a2b42f94c2419",
@@ -372,6 +384,16 @@ var otherFiles = []testFile{
 		md5sum: "00e28a90cb4a975fdaa3b375d3124a66",
 		err:    nil,
 	},
+	{
+		file:   "words-mixed-filters.xz",
+		md5sum: "00e28a90cb4a975fdaa3b375d3124a66",
+		err:    nil,
+	},
+	{
+		file:   "words-blocks.xz",
+		md5sum: "00e28a90cb4a975fdaa3b375d3124a66",
+		err:    nil,
+	},
 	{
 		file:   "random-1mb.xz",
 		md5sum: "3f04b090e5d26a1cbeea53c21ebcad03",
@@ -455,7 +477,7 @@ func testFileList(t *testing.T, files []testFile, reuseReader bool) {
 			if err == nil {
 				_, err = io.Copy(hash, r)
 			}
-			if err != f.err {
+			if !errors.Is(err, f.err) {
 				t.Fatalf("%s: wanted error: %v, got: %v\n", f.file, f.err, err)
 			}
 			md5sum := fmt.Sprintf("%x", hash.Sum(nil))
@@ -494,7 +516,7 @@ func testFileListByteReads(t *testing.T, files []testFile) {
 					err = nil
 				}
 			}
-			if err != f.err {
+			if !errors.Is(err, f.err) {
 				t.Fatalf("%s: wanted error: %v, got: %v\n", f.file, f.err, err)
 			}
 			md5sum := fmt.Sprintf("%x", hash.Sum(nil))
@@ -532,11 +554,224 @@ func TestMemlimit(t *testing.T) {
 		b := new(bytes.Buffer)
 		_, err = io.Copy(b, r)
 	}
-	if err != xz.ErrMemlimit {
+	if !errors.Is(err, xz.ErrMemlimit) {
 		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
 	}
 }
 
+func TestMemLimitOption(t *testing.T) {
+	for _, file := range []string{"words.xz", "good-1-x86-lzma2.xz"} {
+		data, err := readTestFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := func(memLimit uint64) error {
+			r, err := xz.NewReaderOptions(bytes.NewReader(data),
+				&xz.ReaderOptions{MemLimit: memLimit})
+			if err == nil {
+				_, err = io.Copy(ioutil.Discard, r)
+			}
+			return err
+		}
+		err = read(1 << 16)
+		var e *xz.Error
+		if !errors.As(err, &e) || e.Err != xz.ErrMemlimit {
+			t.Fatalf("%s: wanted error: %v, got: %v\n",
+				file, xz.ErrMemlimit, err)
+		}
+		// retry with the memory needed, and one byte less
+		if err = read(e.MemRequired - 1); !errors.Is(err, xz.ErrMemlimit) {
+			t.Fatalf("%s: wanted error: %v, got: %v\n",
+				file, xz.ErrMemlimit, err)
+		}
+		if err = read(e.MemRequired); err != nil {
+			t.Fatalf("%s: wanted error: nil, got: %v\n", file, err)
+		}
+	}
+}
+
+func TestMemUsage(t *testing.T) {
+	// the memory counted for each structure is at least its size
+	for _, m := range xz.MemUsage {
+		if m.Counted < m.Size {
+			t.Fatalf("%s: counted %d bytes, size is %d bytes\n",
+				m.Name, m.Counted, m.Size)
+		}
+	}
+}
+
+func TestMaxOutput(t *testing.T) {
+	for _, test := range []struct {
+		file      string
+		maxOutput int64
+		n         int64
+		reason    string
+	}{
+		// the limit is found in the decoded data
+		{"words.xz", 89412, 89412, "uncompressed data exceeds limit"},
+		{"words.xz", 89413, 89413, ""},
+		{"zeros-100mb.xz", 1 << 20, 1 << 20, "uncompressed data exceeds limit"},
+		// the limit is found in the second block header
+		{"words-blocks.xz", 20000, 16384, "block uncompressed size exceeds limit"},
+	} {
+		data, err := readTestFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, writeTo := range []bool{false, true} {
+			// hide Seek so that the limit is not found in the Index
+			z, err := xz.NewReaderOptions(
+				struct{ io.Reader }{bytes.NewReader(data)},
+				&xz.ReaderOptions{MaxOutput: test.maxOutput})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var n int64
+			if writeTo {
+				n, err = z.WriteTo(ioutil.Discard)
+			} else {
+				n, err = io.Copy(ioutil.Discard, struct{ io.Reader }{z})
+			}
+			if n != test.n {
+				t.Fatalf("%s: wanted %d bytes, got %d\n", test.file, test.n, n)
+			}
+			if test.reason == "" {
+				if err != nil {
+					t.Fatalf("%s: wanted error: nil, got: %v\n", test.file, err)
+				}
+				continue
+			}
+			var e *xz.Error
+			if !errors.As(err, &e) || e.Err != xz.ErrOutputLimit ||
+				e.Reason != test.reason {
+				t.Fatalf("%s: wanted error: %v (%s), got: %v\n",
+					test.file, xz.ErrOutputLimit, test.reason, err)
+			}
+		}
+	}
+}
+
+func TestReaderSize(t *testing.T) {
+	for _, test := range []struct {
+		file string
+		junk bool
+		size int64
+	}{
+		{"words.xz", false, 89413},
+		{"good-2-lzma2.xz", false, 13},
+		{"good-0catpad-empty.xz", false, 0},
+		{"zeros-100mb.xz", false, 100000000},
+		// the Index cannot be found after trailing junk
+		{"words.xz", true, -1},
+	} {
+		data, err := readTestFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.junk {
+			data = append(data[:len(data):len(data)], "junk"...)
+		}
+		// the Index is read from the current position
+		r := bytes.NewReader(append([]byte("prefix"), data...))
+		if _, err = r.Seek(6, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		z, err := xz.NewReader(r, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := z.Size(); size != test.size {
+			t.Fatalf("%s: wanted size %d, got %d\n", test.file, test.size, size)
+		}
+		n, err := io.Copy(ioutil.Discard, z)
+		if test.size >= 0 && (n != test.size || err != nil) {
+			t.Fatalf("%s: read returned: (%d,%v), expected: (%d,nil)\n",
+				test.file, n, err, test.size)
+		}
+		// the size is unknown if r is not an io.ReadSeeker
+		z, err = xz.NewReader(struct{ io.Reader }{bytes.NewReader(data)}, 0)
+		if err == nil && z.Size() != -1 {
+			t.Fatalf("%s: wanted size -1, got %d\n", test.file, z.Size())
+		}
+	}
+}
+
+func TestReaderSizeMaxOutput(t *testing.T) {
+	data, err := readTestFile("zeros-100mb.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = xz.NewReaderOptions(bytes.NewReader(data),
+		&xz.ReaderOptions{MaxOutput: 100000000 - 1})
+	var e *xz.Error
+	if !errors.As(err, &e) || e.Err != xz.ErrOutputLimit ||
+		e.Reason != "index uncompressed size exceeds limit" {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrOutputLimit, err)
+	}
+}
+
+func TestReaderProgress(t *testing.T) {
+	data, err := readTestFile("words-blocks.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := xz.ReadIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []xz.Stats
+	z, err := xz.NewReaderOptions(iotest.HalfReader(bytes.NewReader(data)),
+		&xz.ReaderOptions{Progress: func(st xz.Stats) {
+			calls = append(calls, st)
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1<<20)
+	for err == nil {
+		_, err = z.Read(buf)
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	// each block is seen to start after its header and to end
+	// after its check, among the calls made at the end of Read
+	var want []xz.Stats
+	for i, b := range idx.Streams[0].Blocks {
+		want = append(want, xz.Stats{
+			Compressed:   b.Offset + 16,
+			Uncompressed: b.UncompressedOffset,
+			Block:        i,
+			Blocks:       i,
+		}, xz.Stats{
+			Compressed:   b.Offset + b.CompressedSize,
+			Uncompressed: b.UncompressedOffset + b.UncompressedSize,
+			Block:        -1,
+			Blocks:       i + 1,
+		})
+	}
+	i := 0
+	for _, st := range calls {
+		if i < len(want) && st == want[i] {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Fatalf("wanted call with %+v, got %+v\n", want[i], calls)
+	}
+	end := xz.Stats{
+		Compressed:   int64(len(data)),
+		Uncompressed: idx.UncompressedSize,
+		Stream:       1,
+		Block:        -1,
+		Blocks:       len(want) / 2,
+	}
+	last := calls[len(calls)-1]
+	if st := z.Stats(); st != end || last != end {
+		t.Fatalf("wanted final stats %+v, got %+v and %+v\n", end, st, last)
+	}
+}
+
 // test to ensure that decoder errors are not returned prematurely
 // the test file returns 6 decoded bytes before corruption occurs
 func TestPrematureError(t *testing.T) {
@@ -558,7 +793,7 @@ func TestPrematureError(t *testing.T) {
 		t.Fatalf("Read returned: (%d,%v), expected: (2,%v)\n", n, err, nil)
 	}
 	n, err = r.Read(b)
-	if n != 2 || err != xz.ErrData {
+	if n != 2 || !errors.Is(err, xz.ErrData) {
 		t.Fatalf("Read returned: (%d,%v), expected: (2,%v)\n",
 			n, err, xz.ErrData)
 	}
@@ -575,17 +810,17 @@ func TestMultipleBadReads(t *testing.T) {
 	}
 	b := make([]byte, 100)
 	n, err := r.Read(b)
-	if n != 6 || err != xz.ErrData {
+	if n != 6 || !errors.Is(err, xz.ErrData) {
 		t.Fatalf("Read returned: (%d,%v), expected: (6,%v)\n",
 			n, err, xz.ErrData)
 	}
 	n, err = r.Read(b)
-	if n != 0 || err != xz.ErrData {
+	if n != 0 || !errors.Is(err, xz.ErrData) {
 		t.Fatalf("Read returned: (%d,%v), expected: (0,%v)\n",
 			n, err, xz.ErrData)
 	}
 	n, err = r.Read(b)
-	if n != 0 || err != xz.ErrData {
+	if n != 0 || !errors.Is(err, xz.ErrData) {
 		t.Fatalf("Read returned: (%d,%v), expected: (0,%v)\n",
 			n, err, xz.ErrData)
 	}
@@ -708,3 +943,205 @@ func TestReuseReaderPartialReads(t *testing.T) {
 		}
 	}
 }
+
+// cancelWriter is an io.Writer which calls cancel once more than
+// limit bytes have been written to it.
+type cancelWriter struct {
+	n      int64
+	limit  int64
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if w.n > w.limit {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+// cancelReader is an io.Reader which calls cancel after its first
+// read.
+type cancelReader struct {
+	r      io.Reader
+	reads  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	if r.reads++; r.reads > 1 {
+		r.cancel()
+	}
+	return r.r.Read(p)
+}
+
+func TestReaderContext(t *testing.T) {
+	data, err := readTestFile("words.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := xz.NewReaderContext(
+		context.Background(), bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := md5.New()
+	if _, err = io.Copy(hash, z); err != nil {
+		t.Fatal(err)
+	}
+	md5sum := fmt.Sprintf("%x", hash.Sum(nil))
+	wantedMD5, _ := testFileData("words.xz")
+	if wantedMD5 != md5sum {
+		t.Fatalf("wanted md5: %v, got: %v\n", wantedMD5, md5sum)
+	}
+}
+
+func TestReaderContextCancel(t *testing.T) {
+	data, err := readTestFile("zeros-100mb.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	z, err := xz.NewReaderContext(ctx, bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single large read stops soon after the context is done
+	w := &cancelWriter{limit: 1 << 20, cancel: cancel}
+	b := make([]byte, 1<<20)
+	for err == nil {
+		var n int
+		n, err = z.Read(b)
+		_, _ = w.Write(b[:n])
+	}
+	if err != context.Canceled {
+		t.Fatalf("wanted error: %v, got: %v\n", context.Canceled, err)
+	}
+	if w.n > 3<<20 {
+		t.Fatalf("read %d bytes after cancel\n", w.n)
+	}
+	if _, err = z.Read(b); err != context.Canceled {
+		t.Fatalf("wanted error: %v, got: %v\n", context.Canceled, err)
+	}
+	// a context which is already done stops NewReaderContext
+	_, err = xz.NewReaderContext(ctx, bytes.NewReader(data), 0)
+	if err != context.Canceled {
+		t.Fatalf("wanted error: %v, got: %v\n", context.Canceled, err)
+	}
+	// the context is checked within a read producing a lot of data
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancelReader{r: bytes.NewReader(data), cancel: cancel}
+	z, err = xz.NewReaderContext(ctx, r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := z.Read(make([]byte, 100000000))
+	if n == 100000000 || err != context.Canceled {
+		t.Fatalf("Read returned: (%d,%v), expected: (<100000000,%v)\n",
+			n, err, context.Canceled)
+	}
+}
+
+// readAll returns the data and error from decoding file, using Read
+// if writeTo is false and WriteTo otherwise.
+func readAll(file string, writeTo bool) ([]byte, error) {
+	data, err := readTestFile(file)
+	if err != nil {
+		return nil, err
+	}
+	z, err := xz.NewReader(bytes.NewReader(data), 0)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if writeTo {
+		_, err = z.WriteTo(&buf)
+	} else {
+		// hide WriteTo from io.Copy
+		_, err = io.Copy(&buf, struct{ io.Reader }{z})
+	}
+	return buf.Bytes(), err
+}
+
+func TestWriteTo(t *testing.T) {
+	fileList := badFiles
+	fileList = append(fileList, goodFiles...)
+	fileList = append(fileList, otherFiles...)
+	for _, f := range fileList {
+		readData, readErr := readAll(f.file, false)
+		data, err := readAll(f.file, true)
+		if fmt.Sprint(err) != fmt.Sprint(readErr) {
+			t.Fatalf("%s: wanted error: %v, got: %v\n",
+				f.file, readErr, err)
+		}
+		if !bytes.Equal(data, readData) {
+			t.Fatalf("%s: WriteTo data differs from Read data\n", f.file)
+		}
+	}
+}
+
+// shortWriter is an io.Writer which writes at most n bytes.
+type shortWriter struct {
+	n int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		p = p[:w.n]
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteToShortWrite(t *testing.T) {
+	data, err := readTestFile("words.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := xz.NewReader(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := z.WriteTo(&shortWriter{n: 1000})
+	if n != 1000 || err != io.ErrShortWrite {
+		t.Fatalf("WriteTo returned: (%d,%v), expected: (1000,%v)\n",
+			n, err, io.ErrShortWrite)
+	}
+}
+
+func benchmarkCopy(b *testing.B, file string, writeTo bool) {
+	data, err := readTestFile(file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	z, err := xz.NewReader(nil, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = z.Reset(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+		var n int64
+		if writeTo {
+			n, err = io.Copy(ioutil.Discard, z)
+		} else {
+			n, err = io.Copy(ioutil.Discard, struct{ io.Reader }{z})
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(n)
+	}
+}
+
+func BenchmarkCopyRead(b *testing.B) {
+	benchmarkCopy(b, "zeros-100mb.xz", false)
+}
+
+func BenchmarkCopyWriteTo(b *testing.B) {
+	benchmarkCopy(b, "zeros-100mb.xz", true)
+}
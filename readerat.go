@@ -0,0 +1,353 @@
+/*
+ * Package xz Go ReaderAt API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	errNegativeOffset = errors.New("xz: negative offset")
+	errWhence         = errors.New("xz: invalid whence")
+)
+
+// blockReadersMax is the number of idle block decoders kept by a
+// ReaderAt for reuse.
+const blockReadersMax = 2
+
+// A ReaderAt provides random access to the uncompressed data of an XZ
+// file. It reads the Index of each stream in the file and, for each
+// read, decompresses only the blocks covering the requested range.
+//
+// Random access is only efficient if the file has been compressed
+// into many blocks, as is done for example by "xz --block-size" or by
+// multi-threaded compression with XZ Utils. Reading from the middle
+// of a block requires decompressing the block up to that point.
+//
+// A ReaderAt is safe for concurrent use by multiple goroutines,
+// though Read and Seek share a single offset.
+type ReaderAt struct {
+	r        io.ReaderAt    // the wrapped io.ReaderAt
+	dictMax  uint32         // maximum dictionary size
+	memLimit uint64         // memory usage limit (or 0)
+	index    *xzIndex       // streams and blocks of the file
+	mu       sync.Mutex     // guards off and idle
+	off      int64          // offset used by Read and Seek
+	idle     []*blockReader // decoders not in use by any read
+}
+
+// A blockReader decodes the data of a single block.
+type blockReader struct {
+	Header
+	block int               // index of the block in index.blocks
+	pos   int64             // uncompressed offset within the block
+	sr    *io.SectionReader // the compressed block
+	in    [inBufSize]byte   // backing array for buf.in
+	buf   xzBuf             // decoder input/output buffers
+	dec   *xzDec            // decoder state
+	extra [1]byte           // output space used to detect excess data
+}
+
+// NewReaderAt creates a new ReaderAt reading the XZ file held in the
+// first size bytes of r. The Stream Footer, Index and Stream Header
+// of every stream are read and checked; the blocks are read later as
+// required. dictMax has the same meaning as for NewReader.
+//
+// If a stream uses a check type which is not supported then the
+// ReaderAt is returned along with ErrUnsupportedCheck. The ReaderAt
+// may still be used, but the data of such streams is not verified.
+func NewReaderAt(r io.ReaderAt, size int64, dictMax uint32) (*ReaderAt, error) {
+	return NewReaderAtOptions(r, size, &ReaderOptions{DictMax: dictMax})
+}
+
+// NewReaderAtOptions is like NewReaderAt but takes its settings from
+// opts. Only DictMax and MemLimit are used. A nil opts is equivalent
+// to a zero ReaderOptions.
+//
+// MemLimit limits the memory used by the Index, which is held by the
+// ReaderAt, and by each read, counting the Index, the block decoder
+// and its dictionary and filters. NewReaderAtOptions returns
+// ErrMemlimit if the Index alone needs more, and reads return it if
+// a block does. Concurrent reads each use a block decoder, and up to
+// two idle decoders are kept for later reads.
+func NewReaderAtOptions(r io.ReaderAt, size int64, opts *ReaderOptions) (*ReaderAt, error) {
+	if opts == nil {
+		opts = &ReaderOptions{}
+	}
+	index, ret, err := decFileIndex(r, size, opts.MemLimit)
+	if err != nil {
+		return nil, err
+	}
+	if ret != xzOK {
+		return nil, retError(ret)
+	}
+	z := &ReaderAt{
+		r:        r,
+		dictMax:  opts.dictMax(),
+		memLimit: opts.MemLimit,
+		index:    index,
+	}
+	for _, st := range index.streams {
+		switch st.checkType {
+		case CheckNone, CheckCRC32, CheckCRC64, CheckSHA256:
+		default:
+			return z, ErrUnsupportedCheck
+		}
+	}
+	return z, nil
+}
+
+// Size returns the total size of the uncompressed data.
+func (z *ReaderAt) Size() int64 {
+	return z.index.uncompressed
+}
+
+// ReadAt implements the io.ReaderAt interface, reading len(p) bytes of
+// uncompressed data starting at offset off.
+func (z *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	blocks := z.index.blocks
+	i := sort.Search(len(blocks), func(i int) bool {
+		return blocks[i].uOffset+blocks[i].uncompressed > off
+	})
+	for ; n < len(p) && i < len(blocks); i++ {
+		if blocks[i].uncompressed == 0 {
+			continue
+		}
+		var m int
+		m, err = z.readBlock(i, p[n:], off+int64(n)-blocks[i].uOffset)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Read implements the io.Reader interface, reading from the offset
+// set by Seek.
+func (z *ReaderAt) Read(p []byte) (n int, err error) {
+	z.mu.Lock()
+	off := z.off
+	z.mu.Unlock()
+	n, err = z.ReadAt(p, off)
+	z.mu.Lock()
+	z.off = off + int64(n)
+	z.mu.Unlock()
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements the io.Seeker interface. Offsets are in the
+// uncompressed data.
+func (z *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.off
+	case io.SeekEnd:
+		offset += z.index.uncompressed
+	default:
+		return 0, errWhence
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	z.off = offset
+	return offset, nil
+}
+
+// getBlockReader returns a decoder ready to read block i from an
+// offset no later than pos, preferring an idle decoder already part
+// way through the block.
+func (z *ReaderAt) getBlockReader(i int, pos int64) *blockReader {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	var br *blockReader
+	for j, b := range z.idle {
+		if b.block == i && b.pos <= pos {
+			br = b
+			z.idle = append(z.idle[:j], z.idle[j+1:]...)
+			return br
+		}
+	}
+	if n := len(z.idle); n > 0 {
+		br = z.idle[n-1]
+		z.idle = z.idle[:n-1]
+	} else {
+		br = newBlockReader(z)
+	}
+	br.reset(z, i)
+	return br
+}
+
+// putBlockReader makes br available to later reads. A decoder which
+// has finished its block, or failed, is reset before reuse.
+func (z *ReaderAt) putBlockReader(br *blockReader) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if len(z.idle) == blockReadersMax {
+		z.idle = z.idle[1:]
+	}
+	z.idle = append(z.idle, br)
+}
+
+// readBlock reads into p the data of block i starting at offset pos
+// within the block.
+func (z *ReaderAt) readBlock(i int, p []byte, pos int64) (n int, err error) {
+	blk := &z.index.blocks[i]
+	br := z.getBlockReader(i, pos)
+	// skip forward to pos
+	var skip [inBufSize]byte
+	for br.pos < pos {
+		s := skip[:]
+		if int64(len(s)) > pos-br.pos {
+			s = s[:pos-br.pos]
+		}
+		if _, err = br.decode(z, s); err != nil {
+			br.block = -1
+			z.putBlockReader(br)
+			return 0, err
+		}
+	}
+	if int64(len(p)) > blk.uncompressed-pos {
+		p = p[:blk.uncompressed-pos]
+	}
+	n, err = br.decode(z, p)
+	if err != nil {
+		br.block = -1
+	}
+	z.putBlockReader(br)
+	return n, err
+}
+
+// newBlockReader allocates a block decoder for use with z.
+func newBlockReader(z *ReaderAt) *blockReader {
+	br := new(blockReader)
+	br.dec = xzDecInit(z.dictMax, &br.Header)
+	br.dec.memLimit = z.memLimit
+	br.dec.memCaller = z.index.memUsage + memUsageReader
+	return br
+}
+
+// reset prepares br to decode block i of z.
+func (br *blockReader) reset(z *ReaderAt, i int) {
+	blk := &z.index.blocks[i]
+	xzDecResetBlock(br.dec, z.index.streams[blk.stream].checkType)
+	br.block = i
+	br.pos = 0
+	br.sr = io.NewSectionReader(z.r, blk.offset, (blk.unpadded+3)&^3)
+	br.buf.in = br.in[:0]
+	br.buf.inPos = 0
+}
+
+// header decodes the block header of the block br was reset to,
+// checking the sizes it holds against the Index. No data is decoded.
+func (br *blockReader) header(z *ReaderAt) error {
+	blk := &z.index.blocks[br.block]
+	var err error
+	br.dec.blockStop = true
+	for err == nil && br.dec.sequence != seqBlockUncompress {
+		br.buf.out = br.extra[:0]
+		br.buf.outPos = 0
+		if br.buf.inPos == len(br.buf.in) {
+			var m int
+			m, err = br.sr.Read(br.in[:])
+			if err != nil && err != io.EOF {
+				break
+			}
+			err = nil
+			br.buf.in = br.in[:m]
+			br.buf.inPos = 0
+		}
+		switch ret := xzDecRun(br.dec, &br.buf); ret {
+		case xzOK:
+		case xzStreamEnd:
+			err = ErrData
+		default:
+			err = retError(ret)
+		}
+	}
+	br.dec.blockStop = false
+	if err != nil {
+		return err
+	}
+	h := &br.dec.blockHeader
+	if h.compressed != vliUnknown &&
+		int64(h.compressed) != blk.unpadded-int64(h.size)-
+			int64(checkSizes[br.dec.CheckType]) ||
+		h.uncompressed != vliUnknown &&
+			int64(h.uncompressed) != blk.uncompressed {
+		return ErrData
+	}
+	return nil
+}
+
+// decode fills p with the block's data. p must not extend past the
+// end of the block. When the end of the block is reached its Check
+// field is verified, along with its sizes against the Index.
+func (br *blockReader) decode(z *ReaderAt, p []byte) (n int, err error) {
+	blk := &z.index.blocks[br.block]
+	finish := br.pos+int64(len(p)) == blk.uncompressed
+	for {
+		if n < len(p) {
+			br.buf.out = p[n:]
+		} else if finish {
+			// finish the block, which must not produce more data
+			br.buf.out = br.extra[:]
+		} else {
+			return n, nil
+		}
+		br.buf.outPos = 0
+		if br.buf.inPos == len(br.buf.in) {
+			var m int
+			m, err = br.sr.Read(br.in[:])
+			if err != nil && err != io.EOF {
+				return n, err
+			}
+			br.buf.in = br.in[:m]
+			br.buf.inPos = 0
+		}
+		ret := xzDecRun(br.dec, &br.buf)
+		if n == len(p) && br.buf.outPos > 0 {
+			return n, ErrData
+		}
+		n += br.buf.outPos
+		br.pos += int64(br.buf.outPos)
+		switch ret {
+		case xzOK:
+		case xzStreamEnd:
+			return n, ErrData
+		default:
+			return n, retError(ret)
+		}
+		if br.dec.sequence == seqBlockStart && br.dec.block.count == 1 {
+			// the block, including its Check field, is complete
+			if br.pos != blk.uncompressed ||
+				int64(br.dec.block.hash.unpadded) != blk.unpadded ||
+				int64(br.dec.block.hash.uncompressed) != blk.uncompressed {
+				return n, ErrData
+			}
+			return n, nil
+		}
+	}
+}
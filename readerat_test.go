@@ -0,0 +1,202 @@
+/*
+ * Package xz ReaderAt tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+// multiBlockFile returns a file of several streams and blocks,
+// separated by stream padding, and its uncompressed contents.
+func multiBlockFile(t *testing.T) (file []byte, data []byte) {
+	for _, f := range []string{
+		"words-blocks.xz",
+		"good-0-empty.xz",
+		"good-1-check-sha256.xz",
+		"words-blocks.xz",
+	} {
+		b, err := readTestFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file = append(file, b...)
+		file = append(file, make([]byte, 8)...)
+		data = append(data, decodeTestFile(t, f)...)
+	}
+	return file, data
+}
+
+func TestReaderAt(t *testing.T) {
+	file, data := multiBlockFile(t)
+	r, err := xz.NewReaderAt(bytes.NewReader(file), int64(len(file)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("wanted size: %d, got: %d\n", len(data), r.Size())
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		off := rnd.Int63n(int64(len(data)))
+		p := make([]byte, rnd.Intn(40000))
+		n, err := r.ReadAt(p, off)
+		want := data[off:]
+		if len(want) > len(p) {
+			want = want[:len(p)]
+		} else if err != io.EOF {
+			t.Fatalf("ReadAt at %d: wanted error: %v, got: %v\n",
+				off, io.EOF, err)
+		}
+		if len(want) == len(p) && err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p[:n], want) {
+			t.Fatalf("ReadAt of %d bytes at %d returned different data\n",
+				len(p), off)
+		}
+	}
+	if n, err := r.ReadAt(make([]byte, 1), r.Size()); n != 0 || err != io.EOF {
+		t.Fatalf("wanted: 0 %v, got: %d %v\n", io.EOF, n, err)
+	}
+}
+
+func TestReaderAtConcurrent(t *testing.T) {
+	file, data := multiBlockFile(t)
+	r, err := xz.NewReaderAt(bytes.NewReader(file), int64(len(file)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for j := 0; j < 20; j++ {
+				off := rnd.Int63n(int64(len(data)) - 1000)
+				p := make([]byte, 1000)
+				if _, err := r.ReadAt(p, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(p, data[off:off+1000]) {
+					t.Errorf("ReadAt at %d returned different data\n", off)
+					return
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+}
+
+func TestReaderAtSeek(t *testing.T) {
+	file, data := multiBlockFile(t)
+	r, err := xz.NewReaderAt(bytes.NewReader(file), int64(len(file)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatal("Read returned different data")
+	}
+	off, err := r.Seek(-100, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != int64(len(data))-100 {
+		t.Fatalf("wanted offset: %d, got: %d\n", len(data)-100, off)
+	}
+	b, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data[len(data)-100:]) {
+		t.Fatal("Read after Seek returned different data")
+	}
+	if _, err = r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("Seek to a negative offset succeeded")
+	}
+}
+
+func TestReaderAtFiles(t *testing.T) {
+	for _, f := range []testFile{
+		{file: "good-0cat-empty.xz"},
+		{file: "good-0catpad-empty.xz"},
+		{file: "good-1-block_header-1.xz"},
+		{file: "good-1-delta-lzma2.tiff.xz"},
+		{file: "good-2-lzma2.xz"},
+		{file: "bad-0-backward_size.xz", err: xz.ErrData},
+		{file: "bad-0-empty-truncated.xz", err: xz.ErrData},
+		{file: "bad-0-header_magic.xz", err: xz.ErrFormat},
+		{file: "bad-0catpad-empty.xz", err: xz.ErrData},
+		{file: "bad-1-check-crc32.xz", err: xz.ErrData},
+		{file: "bad-1-lzma2-3.xz", err: xz.ErrData},
+		{file: "bad-2-index-1.xz", err: xz.ErrData},
+		{file: "good-2-lzma2-corrupt.xz", err: xz.ErrData},
+		{file: "unsupported-check.xz", err: xz.ErrUnsupportedCheck},
+	} {
+		file, err := readTestFile(f.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := xz.NewReaderAt(bytes.NewReader(file), int64(len(file)), 0)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if err != f.err {
+			t.Fatalf("%s: wanted error: %v, got: %v\n", f.file, f.err, err)
+		}
+	}
+}
+
+func TestReaderAtMemLimit(t *testing.T) {
+	file, err := readTestFile("words-blocks.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := decodeTestFile(t, "words-blocks.xz")
+	open := func(memLimit uint64) (*xz.ReaderAt, error) {
+		return xz.NewReaderAtOptions(bytes.NewReader(file),
+			int64(len(file)), &xz.ReaderOptions{MemLimit: memLimit})
+	}
+	// the Index alone needs more than the limit
+	if _, err = open(256); err != xz.ErrMemlimit {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
+	}
+	// the 8 MiB dictionary needs more
+	r, err := open(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(data))
+	if _, err = r.ReadAt(b, 0); err != xz.ErrMemlimit {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrMemlimit, err)
+	}
+	r, err = open(9 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.ReadAt(b, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatal("returned different data")
+	}
+}
@@ -0,0 +1,265 @@
+/*
+ * Package xz Go Writer API
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"errors"
+	"io"
+)
+
+// DefaultCheck is the data integrity check type used by a Writer
+// unless Header.CheckType is set otherwise. It is the same as the
+// default of XZ Utils.
+const DefaultCheck = CheckCRC64
+
+// A Preset selects the compression options used by a Writer in the
+// same way as the options -0 to -9 and -e of XZ Utils do. A Preset is
+// a level from 0 to 9, optionally ORed with PresetExtreme.
+//
+// The level sets the dictionary size, and so the memory needed for
+// decompression, to that of XZ Utils. The encoder of this package
+// only implements the fast mode of XZ Utils with the HC4 match finder,
+// which it uses at every level with these settings:
+//
+//	level        0    1    2    3    4    5    6    7    8    9
+//	dictionary   256K 1M   2M   4M   4M   8M   8M   16M  32M  64M
+//	nice length  128  128  273  273  273  273  273  273  273  273
+//	search depth 4    8    24   48   64   96   128  192  256  256
+//
+// The extreme variant of a level uses a nice length of 273 and twice
+// the search depth.
+//
+// Levels 1 to 3 use the same settings as XZ Utils. XZ Utils differs
+// from the table elsewhere, and those of its settings are ignored:
+//
+//   - Level 0 uses the HC3 match finder, not HC4.
+//   - Levels 4 to 9 use the normal mode and the BT4 match finder, with
+//     nice lengths of 16, 32 and then 64 and the default search depth.
+//     The search depths above are this package's own, chosen so that
+//     output does not grow as the level rises.
+//   - Extreme variants use the normal mode and the BT4 match finder,
+//     with a nice length of 192 and the default search depth at levels
+//     3 and 5, and a nice length of 273 and a search depth of 512 at
+//     other levels.
+//
+// So levels 0 and 4 to 9, and the extreme variants, compress
+// differently from XZ Utils, and levels 4 to 9 less well.
+type Preset uint32
+
+const (
+	// PresetExtreme may be ORed with a level to select the
+	// "extreme" variant of the preset, as the option -e does.
+	PresetExtreme Preset = lzmaPresetExtreme
+	// DefaultPreset is the default preset of XZ Utils.
+	DefaultPreset Preset = 6
+)
+
+// errPreset is returned when an invalid Preset is used.
+var errPreset = errors.New("xz: invalid preset")
+
+// presetOptions returns the encoder options selected by preset.
+func presetOptions(preset Preset) (lzma2Options, error) {
+	opts, ok := lzma2PresetOptions(uint32(preset))
+	if !ok {
+		return opts, errPreset
+	}
+	return opts, nil
+}
+
+// errWriterClosed is returned by Write after a Writer has been closed.
+var errWriterClosed = errors.New("xz: write to closed Writer")
+
+// writeChunkSize is the largest amount of input passed to the encoder
+// at once. This bounds the size of the Writer's output buffer.
+const writeChunkSize = 1 << 16 // 64 KiB
+
+// A Writer is an io.WriteCloser. Writes to a Writer are compressed
+// and written to the underlying io.Writer as a single XZ stream
+// containing a single LZMA2 compressed block.
+type Writer struct {
+	Header
+	w           io.Writer    // the wrapped io.Writer
+	wroteHeader bool         // true after the stream header is written
+	closed      bool         // true after Close has been called
+	out         []byte       // encoder output waiting to be written to w
+	enc         *xzEnc       // encoder state
+	err         error        // sticky error
+	opts        lzma2Options // encoder options
+	bcj         Filter       // BCJ filter preceding LZMA2 (ID 0 if none)
+}
+
+// NewWriter returns a new Writer. Writes to the returned Writer are
+// compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the Writer when
+// done. Writes may be buffered and not flushed until Close.
+//
+// Callers that wish to set the fields in Writer.Header must do so
+// before the first call to Write or Close. CheckType defaults to
+// DefaultCheck. Of the check types only CheckNone, CheckCRC32,
+// CheckCRC64 and CheckSHA256 can be used; others cause Write and
+// Close to return ErrUnsupportedCheck.
+//
+// The compression options used are an 8 MiB dictionary, the same as
+// DefaultPreset, with the match finder settings of preset 3, which
+// suit the fast mode of the encoder. Use NewWriterPreset to choose
+// another Preset.
+func NewWriter(w io.Writer) *Writer {
+	z := &Writer{opts: lzma2DefaultOptions}
+	z.init(w)
+	return z
+}
+
+// NewWriterPreset is like NewWriter but compresses using the options
+// selected by preset. It returns an error if preset is invalid.
+func NewWriterPreset(w io.Writer, preset Preset) (*Writer, error) {
+	opts, err := presetOptions(preset)
+	if err != nil {
+		return nil, err
+	}
+	z := &Writer{opts: opts}
+	z.init(w)
+	return z, nil
+}
+
+// WriterOptions holds optional settings for a Writer.
+type WriterOptions struct {
+	// Preset selects the compression options as for
+	// NewWriterPreset. As its zero value is level 0 it is usually
+	// set, for example to DefaultPreset.
+	Preset Preset
+	// Filters is the filter chain applied to the data before it is
+	// compressed with LZMA2, which is always the last filter and
+	// is not listed. It may be empty or hold a single BCJ filter,
+	// with the IDs and start offsets accepted by NewBCJWriter, as
+	// selected by the XZ Utils options --x86, --arm and the like.
+	Filters []Filter
+}
+
+// NewWriterOptions is like NewWriter but compresses using the
+// settings in opts. A nil opts selects the options of NewWriter. It
+// returns ErrOptions if the filter chain is not supported, and an
+// error if the preset is invalid.
+func NewWriterOptions(w io.Writer, opts *WriterOptions) (*Writer, error) {
+	z := &Writer{opts: lzma2DefaultOptions}
+	if opts != nil {
+		var err error
+		if z.opts, err = presetOptions(opts.Preset); err != nil {
+			return nil, err
+		}
+		switch len(opts.Filters) {
+		case 0:
+		case 1:
+			f := opts.Filters[0]
+			if bcjCheck(xzFilterID(f.ID), int(f.Props)) != xzOK {
+				return nil, ErrOptions
+			}
+			z.bcj = f
+		default:
+			return nil, ErrOptions
+		}
+	}
+	z.init(w)
+	return z, nil
+}
+
+func (z *Writer) init(w io.Writer) {
+	z.Header = Header{CheckType: DefaultCheck}
+	z.w = w
+	z.wroteHeader = false
+	z.closed = false
+	z.out = z.out[:0]
+	z.err = nil
+}
+
+// flush writes any buffered output to the underlying io.Writer.
+func (z *Writer) flush() error {
+	if len(z.out) > 0 {
+		_, z.err = z.w.Write(z.out)
+		z.out = z.out[:0]
+	}
+	return z.err
+}
+
+// writeHeader allocates the encoder if needed and writes the stream
+// header.
+func (z *Writer) writeHeader() error {
+	if z.enc == nil {
+		z.enc = xzEncInit(z.opts)
+		xzEncSetBCJ(z.enc, xzFilterID(z.bcj.ID), int(z.bcj.Props))
+	}
+	var ret xzRet
+	z.out, ret = xzEncStreamStart(z.enc, z.out[:0], z.CheckType)
+	if ret == xzUnsupportedCheck {
+		z.err = ErrUnsupportedCheck
+		return z.err
+	}
+	z.wroteHeader = true
+	return z.flush()
+}
+
+// Write writes a compressed form of p to the underlying io.Writer. The
+// compressed bytes are not necessarily flushed until the Writer is
+// closed.
+func (z *Writer) Write(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errWriterClosed
+	}
+	if !z.wroteHeader {
+		if err = z.writeHeader(); err != nil {
+			return 0, err
+		}
+	}
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > writeChunkSize {
+			chunk = chunk[:writeChunkSize]
+		}
+		z.out = xzEncBlockWrite(z.enc, chunk, z.out)
+		if err = z.flush(); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close closes the Writer by flushing any unwritten data to the
+// underlying io.Writer and writing the XZ index and stream footer. It
+// does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if !z.wroteHeader {
+		if err := z.writeHeader(); err != nil {
+			return err
+		}
+	}
+	z.out = xzEncStreamFinish(z.enc, z.out)
+	return z.flush()
+}
+
+// Reset discards the Writer z's state and makes it equivalent to the
+// result of its original state from NewWriter, NewWriterPreset or
+// NewWriterOptions, keeping the same options, but writing to w
+// instead. This permits reusing a Writer rather than allocating a new
+// one.
+func (z *Writer) Reset(w io.Writer) {
+	z.init(w)
+}
@@ -0,0 +1,347 @@
+/*
+ * Package xz Writer tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+// decodeTestFile returns the uncompressed contents of the named test
+// file.
+func decodeTestFile(t *testing.T, file string) []byte {
+	data, err := readTestFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewReader(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	if _, err = io.Copy(b, r); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// roundTrip compresses data with w, writing it in pieces of at most
+// size bytes, and checks that it decompresses back to data.
+func roundTrip(t *testing.T, w *xz.Writer, data []byte, size int) {
+	c := new(bytes.Buffer)
+	w.Reset(c)
+	for p := data; len(p) > 0; {
+		n := size
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewReader(c, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := new(bytes.Buffer)
+	if _, err = io.Copy(d, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d.Bytes(), data) {
+		t.Fatalf("round trip of %d bytes returned different data\n",
+			len(data))
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	w := xz.NewWriter(nil)
+	for _, f := range []string{
+		"good-0-empty.xz",
+		"good-1-x86-lzma2.xz",
+		"good-1-delta-lzma2.tiff.xz",
+		"words.xz",
+		"random-1mb.xz",
+	} {
+		data := decodeTestFile(t, f)
+		for _, size := range []int{1 << 20, 1000, 1} {
+			if size == 1 && len(data) > 1<<16 {
+				continue
+			}
+			roundTrip(t, w, data, size)
+		}
+	}
+}
+
+func TestWriterCheckTypes(t *testing.T) {
+	data := decodeTestFile(t, "words.xz")
+	for _, check := range []xz.CheckID{
+		xz.CheckNone, xz.CheckCRC32, xz.CheckCRC64, xz.CheckSHA256,
+	} {
+		c := new(bytes.Buffer)
+		w := xz.NewWriter(c)
+		w.CheckType = check
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := xz.NewReader(c, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.CheckType != check {
+			t.Fatalf("wanted check type: %v, got: %v\n", check, r.CheckType)
+		}
+		d := new(bytes.Buffer)
+		if _, err = io.Copy(d, r); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d.Bytes(), data) {
+			t.Fatalf("%v: round trip returned different data\n", check)
+		}
+	}
+}
+
+func TestWriterUnsupportedCheck(t *testing.T) {
+	w := xz.NewWriter(new(bytes.Buffer))
+	w.CheckType = 0x02
+	if _, err := w.Write([]byte("data")); err != xz.ErrUnsupportedCheck {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrUnsupportedCheck, err)
+	}
+	if err := w.Close(); err != xz.ErrUnsupportedCheck {
+		t.Fatalf("wanted error: %v, got: %v\n", xz.ErrUnsupportedCheck, err)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	// an empty stream with a CRC32 check is identical to
+	// good-0-empty.xz from XZ Utils
+	want, err := readTestFile("good-0-empty.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(bytes.Buffer)
+	w := xz.NewWriter(c)
+	w.CheckType = xz.CheckCRC32
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Bytes(), want) {
+		t.Fatalf("wanted: %x, got: %x\n", want, c.Bytes())
+	}
+}
+
+func TestWriterPos4GiB(t *testing.T) {
+	// the position of the LZMA encoder is taken close to 4 GiB, so
+	// that it passes 4 GiB at each of the following positions in turn
+	data := decodeTestFile(t, "words.xz")
+	for k := uint64(1); k <= 64; k++ {
+		c := new(bytes.Buffer)
+		w := xz.NewWriter(c)
+		if _, err := w.Write(data[:1<<14]); err != nil {
+			t.Fatal(err)
+		}
+		xz.AddWriterPos(w, 1<<32-(1<<14)-k*16)
+		if _, err := w.Write(data[1<<14:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := xz.NewReader(c, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := new(bytes.Buffer)
+		if _, err = io.Copy(d, r); err != nil {
+			t.Fatalf("offset %d: %v", k*16, err)
+		}
+		if !bytes.Equal(d.Bytes(), data) {
+			t.Fatalf("offset %d: round trip returned different data", k*16)
+		}
+	}
+}
+
+func TestWriterPresets(t *testing.T) {
+	// the dictionary sizes are those of XZ Utils, which the decoder
+	// reads back from the block header
+	data := decodeTestFile(t, "words.xz")
+	for _, test := range []struct {
+		preset   xz.Preset
+		dictSize uint32
+	}{
+		{0, 256 << 10},
+		{1, 1 << 20},
+		{2, 2 << 20},
+		{3, 4 << 20},
+		{4, 4 << 20},
+		{5, 8 << 20},
+		{0 | xz.PresetExtreme, 256 << 10},
+		{xz.DefaultPreset | xz.PresetExtreme, 8 << 20},
+	} {
+		c := new(bytes.Buffer)
+		w, err := xz.NewWriterPreset(c, test.preset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := xz.NewReader(c, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := r.NextBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.DictSize != test.dictSize {
+			t.Fatalf("preset %#x: wanted dictionary size %d, got %d",
+				test.preset, test.dictSize, h.DictSize)
+		}
+		d := new(bytes.Buffer)
+		if _, err = io.Copy(d, r); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d.Bytes(), data) {
+			t.Fatalf("preset %#x: round trip returned different data",
+				test.preset)
+		}
+	}
+}
+
+func TestWriterPresetSizes(t *testing.T) {
+	// the output of typical data does not grow as the level rises;
+	// levels 7 to 9 differ from 6 only in a larger dictionary and
+	// search depth, and are left out as their match finders need a
+	// lot of memory
+	for _, file := range []string{"words.xz", "good-1-arm64-lzma2.xz"} {
+		data := decodeTestFile(t, file)
+		prev := 0
+		for level := xz.Preset(0); level <= 6; level++ {
+			c := new(bytes.Buffer)
+			w, err := xz.NewWriterPreset(c, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if level > 0 && c.Len() > prev {
+				t.Fatalf("%s: preset %d: size %d is larger than %d",
+					file, level, c.Len(), prev)
+			}
+			prev = c.Len()
+		}
+	}
+}
+
+func TestWriterInvalidPreset(t *testing.T) {
+	for _, preset := range []xz.Preset{10, 10 | xz.PresetExtreme, 1 << 8} {
+		if _, err := xz.NewWriterPreset(nil, preset); err == nil {
+			t.Fatalf("preset %#x: wanted error", preset)
+		}
+		if _, err := xz.NewParallelWriterPreset(nil, preset, 0, 0); err == nil {
+			t.Fatalf("preset %#x: wanted error", preset)
+		}
+	}
+}
+
+func TestWriterFilters(t *testing.T) {
+	random := make([]byte, 1<<17)
+	rand.New(rand.NewSource(1)).Read(random)
+	words := decodeTestFile(t, "words.xz")
+	for _, f := range []xz.Filter{
+		{ID: xz.FilterX86},
+		{ID: xz.FilterX86, Props: 2049},
+		{ID: xz.FilterPowerPC},
+		{ID: xz.FilterIA64, Props: 4096},
+		{ID: xz.FilterARM},
+		{ID: xz.FilterARMThumb, Props: 2},
+		{ID: xz.FilterSPARC},
+		{ID: xz.FilterARM64},
+		{ID: xz.FilterARM64, Props: 1 << 12},
+	} {
+		w, err := xz.NewWriterOptions(nil, &xz.WriterOptions{
+			Preset:  xz.DefaultPreset,
+			Filters: []xz.Filter{f},
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", f, err)
+		}
+		for _, data := range [][]byte{random, words, random[:3]} {
+			for _, size := range []int{1 << 20, 1000} {
+				roundTrip(t, w, data, size)
+			}
+			// the filter is stored in the block header
+			c := new(bytes.Buffer)
+			w.Reset(c)
+			if _, err = w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := xz.NewReader(c, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, err := r.NextBlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(h.Filters) != 2 || h.Filters[0] != f ||
+				h.Filters[1].ID != xz.FilterLZMA2 {
+				t.Fatalf("%v: wrong filter chain: %v", f, h.Filters)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%v: %v", f, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%v: round trip returned different data", f)
+			}
+		}
+	}
+}
+
+func TestWriterInvalidFilters(t *testing.T) {
+	for _, filters := range [][]xz.Filter{
+		{{ID: xz.FilterLZMA2}},
+		{{ID: xz.FilterDelta}},
+		{{ID: xz.FilterARM, Props: 2}},
+		{{ID: xz.FilterX86}, {ID: xz.FilterARM}},
+		{{ID: xz.FilterX86}, {ID: xz.FilterLZMA2}},
+	} {
+		_, err := xz.NewWriterOptions(nil, &xz.WriterOptions{
+			Preset:  xz.DefaultPreset,
+			Filters: filters,
+		})
+		if err != xz.ErrOptions {
+			t.Fatalf("%v: wanted error: %v, got: %v", filters, xz.ErrOptions, err)
+		}
+	}
+}
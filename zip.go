@@ -0,0 +1,131 @@
+/*
+ * Package xz Go archive/zip decompressors
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Compression methods of ZIP archive members, as assigned in the ZIP
+// File Format Specification (APPNOTE.TXT).
+const (
+	ZipMethodLZMA uint16 = 14 // LZMA1 with a properties header
+	ZipMethodXZ   uint16 = 95 // an XZ file
+)
+
+/*
+ * The data of a ZIP member using method 14 begins with a 4 byte
+ * header: the LZMA SDK major and minor version numbers followed by
+ * the size of the LZMA properties as a LE16, which is always 5. The
+ * properties byte and LE32 dictionary size follow, then the LZMA1
+ * stream itself.
+ */
+const (
+	zipLZMAHeaderSize = 4
+	zipLZMAPropsSize  = 5
+	/* General purpose flag set when the stream ends with a marker */
+	zipLZMAFlagEOS = 1 << 1
+)
+
+var errZipClosed = errors.New("xz: read after Close")
+
+// RegisterZipDecompressors registers decompressors for the LZMA
+// (ZipMethodLZMA) and XZ (ZipMethodXZ) compression methods with the
+// archive/zip Reader r, so that the members of r using them can be
+// opened. The decompressors use dictionary sizes up to
+// DefaultDictMax bytes.
+//
+// An LZMA member whose general purpose flags do not indicate an end
+// of stream marker is decoded using the uncompressed size from the
+// archive. As archive/zip does not pass the member to the
+// decompressor, the member is found from the offset of its data; if
+// that fails the stream must end with a marker.
+func RegisterZipDecompressors(r *zip.Reader) {
+	var (
+		once  sync.Once
+		files map[int64]*zip.File
+	)
+	lookup := func(rd io.Reader) *zip.File {
+		sr, ok := rd.(interface {
+			Outer() (io.ReaderAt, int64, int64)
+		})
+		if !ok {
+			return nil
+		}
+		once.Do(func() {
+			files = make(map[int64]*zip.File, len(r.File))
+			for _, f := range r.File {
+				if f.Method != ZipMethodLZMA {
+					continue
+				}
+				if off, err := f.DataOffset(); err == nil {
+					files[off] = f
+				}
+			}
+		})
+		_, off, _ := sr.Outer()
+		return files[off]
+	}
+	r.RegisterDecompressor(ZipMethodLZMA, func(rd io.Reader) io.ReadCloser {
+		size := int64(-1)
+		if f := lookup(rd); f != nil && f.Flags&zipLZMAFlagEOS == 0 {
+			size = int64(f.UncompressedSize64)
+		}
+		z, err := newZipLZMAReader(rd, size)
+		return &zipReadCloser{r: z, err: err}
+	})
+	r.RegisterDecompressor(ZipMethodXZ, func(rd io.Reader) io.ReadCloser {
+		z, err := NewReader(rd, 0)
+		return &zipReadCloser{r: z, err: err}
+	})
+}
+
+// newZipLZMAReader reads the properties header of the LZMA member data
+// held in r and returns an LZMAReader for the stream which follows.
+// size is the uncompressed size, or negative if the stream ends with a
+// marker.
+func newZipLZMAReader(r io.Reader, size int64) (*LZMAReader, error) {
+	var hdr [zipLZMAHeaderSize + zipLZMAPropsSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrFormat
+		}
+		return nil, err
+	}
+	if hdr[2] != zipLZMAPropsSize || hdr[3] != 0 {
+		return nil, ErrFormat
+	}
+	props := hdr[zipLZMAHeaderSize:]
+	return NewLZMA1Reader(r, props[0], getLE32(props[1:]), size)
+}
+
+// A zipReadCloser is the io.ReadCloser returned by the decompressors,
+// holding any error from reading the member's header.
+type zipReadCloser struct {
+	r   io.Reader
+	err error
+}
+
+func (z *zipReadCloser) Read(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	return z.r.Read(p)
+}
+
+func (z *zipReadCloser) Close() error {
+	if z.err == nil {
+		z.err = errZipClosed
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+ * Package xz archive/zip decompressor tests
+ *
+ * Author: Michael Cross <https://github.com/xi2>
+ *
+ * This file has been put into the public domain.
+ * You can do whatever you want with this file.
+ */
+
+package xz_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+
+	"github.com/xi2/xz"
+)
+
+// A zipMember is the raw data of a member of a test ZIP archive.
+type zipMember struct {
+	name   string
+	method uint16
+	flags  uint16
+	data   []byte // compressed data
+	want   []byte // uncompressed data
+}
+
+// zipLZMA returns the ZIP method 14 data for the .lzma file held in
+// lzma, replacing its header with a ZIP LZMA properties header.
+func zipLZMA(lzma []byte) []byte {
+	return append([]byte{9, 20, 5, 0}, lzma[:5]...)[:9:9]
+}
+
+// newZip returns an archive/zip Reader, with the decompressors
+// registered, for an archive holding members.
+func newZip(t *testing.T, members []zipMember) *zip.Reader {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, m := range members {
+		fw, err := w.CreateRaw(&zip.FileHeader{
+			Name:               m.name,
+			Method:             m.method,
+			Flags:              m.flags,
+			CRC32:              crc32.ChecksumIEEE(m.want),
+			CompressedSize64:   uint64(len(m.data)),
+			UncompressedSize64: uint64(len(m.want)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fw.Write(m.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xz.RegisterZipDecompressors(r)
+	return r
+}
+
+// readZipFile returns the uncompressed data of f.
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func TestZipDecompressors(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lzma, err := readTestFile("words.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known, err := readTestFile("words-20k-known_size.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xzData, err := readTestFile("words.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := []zipMember{
+		{"eos", xz.ZipMethodLZMA, 1 << 1,
+			append(zipLZMA(lzma), lzma[13:]...), words},
+		{"sized", xz.ZipMethodLZMA, 0,
+			append(zipLZMA(known), known[13:]...), words[:20000]},
+		{"xz", xz.ZipMethodXZ, 0, xzData, words},
+	}
+	r := newZip(t, members)
+	for i, f := range r.File {
+		b, err := readZipFile(f)
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if !bytes.Equal(b, members[i].want) {
+			t.Fatalf("%s: returned different data", f.Name)
+		}
+	}
+}
+
+func TestZipDecompressorsErrors(t *testing.T) {
+	words := decodeTestFile(t, "words.xz")
+	lzma, err := readTestFile("words.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badSize := zipLZMA(lzma)
+	badSize[2] = 6
+	badProps := zipLZMA(lzma)
+	badProps[4] = 0xff
+	for i, test := range []struct {
+		data []byte
+		err  error
+	}{
+		{zipLZMA(lzma)[:6], xz.ErrFormat},
+		{append(badSize, lzma[13:]...), xz.ErrFormat},
+		{append(badProps, lzma[13:]...), xz.ErrOptions},
+		{append(zipLZMA(lzma), lzma[13:len(lzma)-1]...), xz.ErrBuf},
+	} {
+		r := newZip(t, []zipMember{
+			{"test", xz.ZipMethodLZMA, 1 << 1, test.data, words},
+		})
+		if _, err := readZipFile(r.File[0]); err != test.err {
+			t.Fatalf("test %d: wanted error: %v, got: %v\n", i, test.err, err)
+		}
+	}
+}